@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"Line01/db"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// ผู้ดูแลระบบ: LINE user ID จาก ADMIN_USER_IDS (คั่นด้วย comma)
+var adminUserIDs = make(map[string]bool)
+
+// initAdmins loads the admin user IDs from ADMIN_USER_IDS
+func initAdmins() {
+	for _, id := range strings.Split(os.Getenv("ADMIN_USER_IDS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			adminUserIDs[id] = true
+		}
+	}
+	slog.Info("admins configured", "count", len(adminUserIDs))
+}
+
+// isAdmin reports whether userID may run admin commands
+func isAdmin(userID string) bool {
+	return userID != "" && adminUserIDs[userID]
+}
+
+// isSuspended reports whether userID has been suspended by an admin. Admins
+// are never considered suspended so they cannot lock themselves out.
+func isSuspended(ctx context.Context, userID string) bool {
+	if userID == "" || isAdmin(userID) {
+		return false
+	}
+	_, err := queries.GetSuspension(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "error checking suspension", "user_id", userID, "error", err)
+		return false
+	}
+	return true
+}
+
+// handleAdminCommand runs an "admin ..." command. handleTextMessage has
+// already checked that the sender is an admin and the arguments fit the
+// subcommand.
+func handleAdminCommand(ctx context.Context, event *linebot.Event, cmd command, entry *auditEntry) {
+	adminID := event.Source.UserID
+	args := cmd.Args
+
+	switch cmd.Spec.Name {
+	case "stats":
+		stats, err := queries.GetGlobalStats(ctx)
+		entry.Command, entry.Err = "admin stats", err
+		if err != nil {
+			slog.ErrorContext(ctx, "error getting stats", "error", err)
+			reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrStats), err)))
+			return
+		}
+		text := t(ctx, msgStats,
+			stats.Owners, stats.Files, stats.Objects, formatBytes(stats.StoredBytes), stats.ActiveShares, stats.SuspendedUsers)
+		reply(ctx, event, linebot.NewTextMessage(text))
+
+	case "files":
+		ownerID := args[0]
+		rows, err := queries.ListOwnerFiles(ctx, ownerID)
+		entry.Command, entry.Target, entry.Err = "admin files", ownerID, err
+		if err != nil {
+			slog.ErrorContext(ctx, "error listing files", "owner_id", ownerID, "error", err)
+			reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrListFiles), err)))
+			return
+		}
+		if len(rows) == 0 {
+			reply(ctx, event, linebot.NewTextMessage(t(ctx, msgNoFilesOf, ownerID)))
+			return
+		}
+		files := make([]fileListing, 0, len(rows))
+		for _, row := range rows {
+			files = append(files, fileListing{Name: fmt.Sprintf("%s/%s (%s)", row.Category, row.Name, formatBytes(row.Size.Int64))})
+		}
+		reply(ctx, event, fileListFlex(ctx, t(ctx, msgFilesOf, ownerID), files))
+
+	case "suspend":
+		userID := args[0]
+		if isAdmin(userID) {
+			entry.Command, entry.Target, entry.Err = "admin suspend", userID, errUsage
+			reply(ctx, event, linebot.NewTextMessage(t(ctx, msgAdminNotSuspendable)))
+			return
+		}
+		reason := ""
+		if len(args) > 1 {
+			reason = args[1]
+		}
+		err := queries.SuspendUser(ctx, db.SuspendUserParams{
+			UserID:      userID,
+			Reason:      sql.NullString{String: reason, Valid: reason != ""},
+			SuspendedBy: adminID,
+		})
+		entry.Command, entry.Target, entry.Err = "admin suspend", userID, err
+		if err != nil {
+			slog.ErrorContext(ctx, "error suspending user", "user_id", userID, "error", err)
+			reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrSuspend), err)))
+			return
+		}
+		reply(ctx, event, linebot.NewTextMessage(t(ctx, msgUserSuspended, userID)))
+
+	case "unsuspend":
+		userID := args[0]
+		n, err := queries.UnsuspendUser(ctx, userID)
+		entry.Command, entry.Target, entry.Err = "admin unsuspend", userID, err
+		if err != nil {
+			slog.ErrorContext(ctx, "error unsuspending user", "user_id", userID, "error", err)
+			reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrUnsuspend), err)))
+			return
+		}
+		if n == 0 {
+			reply(ctx, event, linebot.NewTextMessage(t(ctx, msgUserNotSuspended, userID)))
+			return
+		}
+		reply(ctx, event, linebot.NewTextMessage(t(ctx, msgUserUnsuspended, userID)))
+
+	case "delete":
+		ownerID, filename := args[0], args[1]
+		err := deleteFile(ctx, ownerID, filename)
+		entry.Command, entry.Target, entry.Err = "admin delete", ownerID+"/"+filename, err
+		if errors.Is(err, errFileNotFound) {
+			reply(ctx, event, linebot.NewTextMessage(t(ctx, msgFileNotFound)))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "error force-deleting file", "owner_id", ownerID, "file", filename, "error", err)
+			reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrDelete), err)))
+			return
+		}
+		reply(ctx, event, linebot.NewTextMessage(t(ctx, msgAdminDeleted, ownerID, filename)))
+
+	case "quota":
+		ownerID := args[0]
+		switch {
+		case len(args) == 1:
+			message, err := quotaMessage(ctx, ownerID, t(ctx, msgStorageOf, ownerID))
+			entry.Command, entry.Target, entry.Err = "admin quota", ownerID, err
+			if err != nil {
+				slog.ErrorContext(ctx, "error reading quota", "owner_id", ownerID, "error", err)
+				reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrReadUsage), err)))
+				return
+			}
+			reply(ctx, event, message)
+
+		case args[1] == "reset":
+			_, err := queries.DeleteQuota(ctx, ownerID)
+			entry.Command, entry.Target, entry.Err = "admin quota reset", ownerID, err
+			if err != nil {
+				slog.ErrorContext(ctx, "error resetting quota", "owner_id", ownerID, "error", err)
+				reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrResetQuota), err)))
+				return
+			}
+			reply(ctx, event, linebot.NewTextMessage(t(ctx, msgQuotaReset, ownerID)))
+
+		case len(args) == 3:
+			maxBytes, err := parseBytes(args[1])
+			if err != nil {
+				entry.Err = errUsage
+				reply(ctx, event, linebot.NewTextMessage(t(ctx, msgInvalidValue, err)))
+				return
+			}
+			maxFiles, err := parseCount(args[2])
+			if err != nil {
+				entry.Err = errUsage
+				reply(ctx, event, linebot.NewTextMessage(t(ctx, msgInvalidValue, err)))
+				return
+			}
+			err = queries.SetQuota(ctx, db.SetQuotaParams{
+				OwnerID:   ownerID,
+				MaxBytes:  sql.NullInt64{Int64: maxBytes, Valid: true},
+				MaxFiles:  sql.NullInt64{Int64: maxFiles, Valid: true},
+				UpdatedBy: sql.NullString{String: adminID, Valid: true},
+			})
+			entry.Command, entry.Target, entry.Err = "admin quota set", fmt.Sprintf("%s %d %d", ownerID, maxBytes, maxFiles), err
+			if err != nil {
+				slog.ErrorContext(ctx, "error setting quota", "owner_id", ownerID, "error", err)
+				reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrSetQuota), err)))
+				return
+			}
+			reply(ctx, event, linebot.NewTextMessage(t(ctx, msgQuotaSet, ownerID, formatBytes(maxBytes), maxFiles)))
+
+		default:
+			entry.Err = newUsageError(msgMissingArgs)
+			reply(ctx, event, linebot.NewTextMessage(usageReply(ctx, cmd, entry.Err)))
+		}
+
+	case "broadcast":
+		notice := "📢 " + args[0]
+		_, err := bot.BroadcastMessage(linebot.NewTextMessage(notice)).WithContext(ctx).Do()
+		entry.Command, entry.Target, entry.Err = "admin broadcast", notice, err
+		if err != nil {
+			slog.ErrorContext(ctx, "error broadcasting notice", "error", err)
+			reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrBroadcast), err)))
+			return
+		}
+		reply(ctx, event, linebot.NewTextMessage(t(ctx, msgBroadcastSent)))
+	}
+}
+
+// formatBytes renders a byte count for humans, e.g. 1.5 MB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"Line01/db"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// auditListLimit caps the entries shown by the audit chat command
+const auditListLimit = 20
+
+// ผลลัพธ์ใน audit_log.result
+const (
+	auditResultOK      = "ok"
+	auditResultError   = "error"
+	auditResultInvalid = "invalid"
+	auditResultDenied  = "denied"
+	auditResultLimited = "limited"
+)
+
+var (
+	errUsage     = errors.New("invalid arguments")
+	errNotAdmin  = errors.New("not an admin")
+	errSuspended = errors.New("user is suspended")
+)
+
+// auditEntry collects the outcome of one handled event; handlers fill in the
+// target and error as they go and the entry is written once at the end
+type auditEntry struct {
+	ctx     context.Context
+	Source  *linebot.EventSource
+	Command string
+	Target  string
+	Err     error
+}
+
+// newAudit starts the audit entry of an event
+func newAudit(ctx context.Context, source *linebot.EventSource, command string) *auditEntry {
+	return &auditEntry{ctx: ctx, Source: source, Command: command}
+}
+
+// record writes the entry to the audit trail
+func (a *auditEntry) record() {
+	recordAudit(a.ctx, a.Source, a.Command, a.Target, a.Err)
+}
+
+// auditResult classifies an error for the result column
+func auditResult(err error) string {
+	switch {
+	case err == nil:
+		return auditResultOK
+	case errors.Is(err, errUsage):
+		return auditResultInvalid
+	case errors.Is(err, errNotAdmin), errors.Is(err, errSuspended):
+		return auditResultDenied
+	case errors.Is(err, errRateLimited):
+		return auditResultLimited
+	default:
+		return auditResultError
+	}
+}
+
+// recordAudit appends an entry to the audit trail and counts the command in
+// the metrics. Failures are logged but never block the action being audited.
+func recordAudit(ctx context.Context, source *linebot.EventSource, command, target string, actionErr error) {
+	commandsTotal.WithLabelValues(command, auditResult(actionErr)).Inc()
+
+	// The entry is written even if the event ran out of time or was cancelled
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), replyTimeout)
+	defer cancel()
+
+	errText := sql.NullString{}
+	if actionErr != nil {
+		errText = sql.NullString{String: actionErr.Error(), Valid: true}
+	}
+
+	err := queries.InsertAuditLog(ctx, db.InsertAuditLogParams{
+		UserID:   source.UserID,
+		SourceID: sourceOwnerID(source),
+		Command:  command,
+		Target:   sql.NullString{String: target, Valid: target != ""},
+		Result:   auditResult(actionErr),
+		Error:    errText,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "error writing audit log", "command", command, "target", target, "error", err)
+	}
+}
+
+// parseSince accepts a lookback ("90m", "12h", "7d") or a date (2006-01-02)
+// and returns the time it refers to
+func parseSince(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("invalid time %q", s)
+		}
+		return time.Now().AddDate(0, 0, -n), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// handleAuditCommand shows the latest audit entries, optionally for one user
+// and since a given time: audit [user] [since]. Only admins may use it, which
+// handleTextMessage has already checked.
+func handleAuditCommand(ctx context.Context, event *linebot.Event, cmd command, entry *auditEntry) {
+	args := cmd.Args
+	entry.Target = strings.Join(args, " ")
+
+	params := db.ListAuditLogParams{Since: time.Now().AddDate(0, 0, -1), MaxRows: auditListLimit}
+	switch len(args) {
+	case 0:
+	case 1:
+		// a single argument is either a time or a user
+		if since, err := parseSince(args[0]); err == nil {
+			params.Since = since
+		} else {
+			params.UserID = sql.NullString{String: args[0], Valid: true}
+		}
+	case 2:
+		since, err := parseSince(args[1])
+		if err != nil {
+			entry.Err = errUsage
+			reply(ctx, event, linebot.NewTextMessage(t(ctx, msgInvalidValue, err)))
+			return
+		}
+		params.UserID = sql.NullString{String: args[0], Valid: true}
+		params.Since = since
+	}
+
+	rows, err := queries.ListAuditLog(ctx, params)
+	if err != nil {
+		entry.Err = err
+		slog.ErrorContext(ctx, "error reading audit log", "error", err)
+		reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrReadAudit), err)))
+		return
+	}
+	if len(rows) == 0 {
+		reply(ctx, event, linebot.NewTextMessage(t(ctx, msgNoAuditEntries)))
+		return
+	}
+
+	lines := make([]string, 0, len(rows)+1)
+	lines = append(lines, t(ctx, msgAuditHeader, len(rows), params.Since.Format("2006-01-02 15:04")))
+	for _, row := range rows {
+		line := fmt.Sprintf("%s %s %s", row.CreatedAt.Local().Format("01-02 15:04"), row.UserID, row.Command)
+		if row.Target.Valid {
+			line += " " + row.Target.String
+		}
+		line += " → " + row.Result
+		if row.Error.Valid {
+			line += " (" + row.Error.String + ")"
+		}
+		lines = append(lines, line)
+	}
+	text := strings.Join(lines, "\n")
+	if len([]rune(text)) > 5000 { // ข้อความ LINE ยาวได้สูงสุด 5000 ตัวอักษร
+		text = string([]rune(text)[:4997]) + "..."
+	}
+	reply(ctx, event, linebot.NewTextMessage(text))
+}
+
+// auditRecord is the JSON form of an audit entry
+type auditRecord struct {
+	ID        int64     `json:"id"`
+	UserID    string    `json:"user_id"`
+	SourceID  string    `json:"source_id"`
+	Command   string    `json:"command"`
+	Target    string    `json:"target,omitempty"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// runAuditExport writes audit entries to stdout as JSON lines.
+//
+// Usage: audit [-user ID] [-since 7d|2006-01-02]
+func runAuditExport(args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	user := flags.String("user", "", "only entries of this LINE user ID")
+	since := flags.String("since", "30d", "only entries newer than this lookback or date")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	from, err := parseSince(*since)
+	if err != nil {
+		return err
+	}
+	rows, err := queries.ExportAuditLog(context.Background(), db.ExportAuditLogParams{
+		UserID: sql.NullString{String: *user, Valid: *user != ""},
+		Since:  from,
+	})
+	if err != nil {
+		return fmt.Errorf("error reading audit log: %w", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, row := range rows {
+		err := enc.Encode(auditRecord{
+			ID:        row.ID,
+			UserID:    row.UserID,
+			SourceID:  row.SourceID,
+			Command:   row.Command,
+			Target:    row.Target.String,
+			Result:    row.Result,
+			Error:     row.Error.String,
+			CreatedAt: row.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
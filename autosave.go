@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"Line01/db"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// autosaveNameAttempts bounds the suffixes tried when a generated name is taken
+const autosaveNameAttempts = 10
+
+// handleAutosaveCommand handles "autosave on [category]", "autosave off" and
+// "autosave status" for the group or room the command was sent in
+func handleAutosaveCommand(ctx context.Context, event *linebot.Event, cmd command, entry *auditEntry) {
+	entry.Target = strings.Join(append([]string{cmd.Spec.Name}, cmd.Args...), " ")
+	if !isGroupSource(event.Source) {
+		entry.Err = errUsage
+		reply(ctx, event, linebot.NewTextMessage(t(ctx, msgAutosaveGroupsOnly)))
+		return
+	}
+
+	ownerID := sourceOwnerID(event.Source)
+
+	switch cmd.Spec.Name {
+	case "on":
+		// autosave on photos and autosave on -c photos both work
+		category := "default"
+		if len(cmd.Args) > 0 {
+			category = cmd.Args[0]
+		}
+		category = cmd.Flag("category", category)
+		err := queries.UpsertAutosave(ctx, db.UpsertAutosaveParams{
+			OwnerID:   ownerID,
+			Enabled:   true,
+			Category:  category,
+			UpdatedBy: sql.NullString{String: event.Source.UserID, Valid: event.Source.UserID != ""},
+		})
+		if err != nil {
+			entry.Err = err
+			slog.ErrorContext(ctx, "error enabling autosave", "error", err)
+			reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrAutosaveOn), err)))
+			return
+		}
+		reply(ctx, event, linebot.NewTextMessage(t(ctx, msgAutosaveOn, category)))
+
+	case "off":
+		setting, err := queries.GetAutosave(ctx, ownerID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			entry.Err = err
+			slog.ErrorContext(ctx, "error reading autosave setting", "error", err)
+			reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrAutosaveOff), err)))
+			return
+		}
+		category := setting.Category
+		if category == "" {
+			category = "default"
+		}
+		err = queries.UpsertAutosave(ctx, db.UpsertAutosaveParams{
+			OwnerID:   ownerID,
+			Enabled:   false,
+			Category:  category,
+			UpdatedBy: sql.NullString{String: event.Source.UserID, Valid: event.Source.UserID != ""},
+		})
+		if err != nil {
+			entry.Err = err
+			slog.ErrorContext(ctx, "error disabling autosave", "error", err)
+			reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrAutosaveOff), err)))
+			return
+		}
+		reply(ctx, event, linebot.NewTextMessage(t(ctx, msgAutosaveOff)))
+
+	case "status":
+		setting, err := queries.GetAutosave(ctx, ownerID)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !setting.Enabled) {
+			reply(ctx, event, linebot.NewTextMessage(t(ctx, msgAutosaveOff)))
+			return
+		}
+		if err != nil {
+			entry.Err = err
+			slog.ErrorContext(ctx, "error reading autosave setting", "error", err)
+			reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrReadAutosave), err)))
+			return
+		}
+		reply(ctx, event, linebot.NewTextMessage(t(ctx, msgAutosaveStatusOn, setting.Category)))
+	}
+}
+
+// autosaveMedia stores a media message posted in a group or room that has
+// autosave enabled. It never replies so the chat is not flooded; failures
+// are only logged.
+func autosaveMedia(ctx context.Context, event *linebot.Event, message linebot.Message) {
+	ownerID := sourceOwnerID(event.Source)
+
+	setting, err := queries.GetAutosave(ctx, ownerID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !setting.Enabled) {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "error reading autosave setting", "owner_id", ownerID, "error", err)
+		return
+	}
+	if isSuspended(ctx, event.Source.UserID) {
+		return // 🚫 Media of suspended members are not archived
+	}
+
+	entry := newAudit(ctx, event.Source, "autosave media")
+	defer entry.record()
+
+	// Autosaved media count as uploads; over the limit they are skipped quietly
+	if !allowCommand(ctx, event.Source, rateClassUpload) {
+		entry.Err = errRateLimited
+		slog.InfoContext(ctx, "autosave: skipping media over the upload rate limit")
+		return
+	}
+
+	// 📦 Skip media that would not fit before downloading them
+	var announcedSize int64
+	if msg, ok := message.(*linebot.FileMessage); ok {
+		announcedSize = int64(msg.FileSize)
+	}
+	if err := checkQuota(ctx, ownerID, announcedSize); err != nil {
+		if errors.Is(err, errQuotaExceeded) {
+			entry.Err = err
+			slog.InfoContext(ctx, "autosave: skipping media, library is full", "owner_id", ownerID)
+			return
+		}
+		slog.ErrorContext(ctx, "autosave: error checking quota", "error", err)
+	}
+
+	fileData, ext, err := downloadMessageContent(ctx, message)
+	if errors.Is(err, errUnsupportedMessage) {
+		return
+	}
+	if err != nil {
+		entry.Err = err
+		slog.ErrorContext(ctx, "autosave: error getting message content", "error", err)
+		return
+	}
+
+	base := autosaveName(ctx, event, message)
+	entry.Target = base + ext
+	filename, err := reserveAutosaveName(ctx, ownerID, event.Source.UserID, base, setting.Category)
+	if err != nil {
+		entry.Err = err
+		slog.ErrorContext(ctx, "autosave: error saving metadata", "file", base, "error", err)
+		return
+	}
+
+	entry.Target = filename + ext
+	if _, _, err := storeFileContent(ctx, ownerID, filename, ext, fileData); err != nil {
+		entry.Err = err
+		slog.ErrorContext(ctx, "autosave: error storing file", "file", filename+ext, "error", err)
+		releaseAutosaveName(ctx, ownerID, event.Source.UserID, filename)
+		return
+	}
+	slog.InfoContext(ctx, "autosaved file", "file", filename+ext, "category", setting.Category, "owner_id", ownerID)
+}
+
+// autosaveName builds <sender>_<timestamp>_<original name> for a media message
+func autosaveName(ctx context.Context, event *linebot.Event, message linebot.Message) string {
+	original := "file"
+	switch msg := message.(type) {
+	case *linebot.FileMessage:
+		original = strings.TrimSuffix(msg.FileName, filepath.Ext(msg.FileName))
+	case *linebot.ImageMessage:
+		original = "image"
+	case *linebot.VideoMessage:
+		original = "video"
+	}
+
+	timestamp := event.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	return sanitizeName(senderName(ctx, event.Source)) + "_" +
+		timestamp.Format("20060102-150405") + "_" +
+		sanitizeName(original)
+}
+
+// senderName returns the display name of whoever sent a group or room
+// message, falling back to a short form of their user ID
+func senderName(ctx context.Context, source *linebot.EventSource) string {
+	var profile *linebot.UserProfileResponse
+	var err error
+	switch source.Type {
+	case linebot.EventSourceTypeGroup:
+		profile, err = bot.GetGroupMemberProfile(source.GroupID, source.UserID).WithContext(ctx).Do()
+	case linebot.EventSourceTypeRoom:
+		profile, err = bot.GetRoomMemberProfile(source.RoomID, source.UserID).WithContext(ctx).Do()
+	}
+	if err == nil && profile != nil && profile.DisplayName != "" {
+		return profile.DisplayName
+	}
+
+	if len(source.UserID) > 8 {
+		return source.UserID[:8]
+	}
+	if source.UserID != "" {
+		return source.UserID
+	}
+	return "unknown"
+}
+
+// sanitizeName makes s usable as a single command argument by replacing
+// whitespace and path separators
+func sanitizeName(s string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if name == "" {
+		return "file"
+	}
+	return name
+}
+
+// reserveAutosaveName creates the pending file row for base, appending -2, -3
+// ... if a file with that name already exists
+func reserveAutosaveName(ctx context.Context, ownerID, uploadedBy, base, category string) (string, error) {
+	for i := 1; i <= autosaveNameAttempts; i++ {
+		name := base
+		if i > 1 {
+			name = fmt.Sprintf("%s-%d", base, i)
+		}
+		err := insertFileMetadata(ctx, ownerID, uploadedBy, name, category)
+		if errors.Is(err, errFileExists) {
+			continue
+		}
+		return name, err
+	}
+	return "", errFileExists
+}
+
+// releaseAutosaveName removes the pending row reserved for a file that could
+// not be stored, so it does not linger in the library without content
+func releaseAutosaveName(ctx context.Context, ownerID, uploadedBy, name string) {
+	// The event may have failed by running out of time
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), replyTimeout)
+	defer cancel()
+
+	err := queries.DeletePendingFile(ctx, db.DeletePendingFileParams{
+		OwnerID:    ownerID,
+		Name:       name,
+		UploadedBy: sql.NullString{String: uploadedBy, Valid: uploadedBy != ""},
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "autosave: error removing pending file", "file", name, "error", err)
+	}
+}
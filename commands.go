@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// commandSpec defines a text command: its name, aliases, flags and arguments.
+// Parsing and the usage text shown on mistakes are both derived from it.
+type commandSpec struct {
+	Name        string
+	Aliases     map[string][]string // extra names by language, shown in that language's help
+	Flags       []flagSpec
+	Args        []argSpec
+	Subcommands []*commandSpec // the first argument picks one of these
+	Admin       bool           // only for admins and left out of the help
+
+	// ExtraArgs may explain arguments beyond those the command takes, such
+	// as an older syntax; nil falls back to the generic message
+	ExtraArgs func(args []string) *usageError
+}
+
+// flagSpec is an option taking a value: --long value, --long=value or -s value
+type flagSpec struct {
+	Long  string
+	Short string
+	Value msgKey // placeholder of the value in usage text
+}
+
+// argSpec is a positional argument
+type argSpec struct {
+	Name     msgKey // placeholder in usage text
+	Optional bool
+	Repeated bool // takes all remaining arguments, at least one unless Optional
+	Rest     bool // takes the rest of the text as typed, quotes and spacing included
+	NonEmpty bool // rejects "" typed in quotes, e.g. for names
+}
+
+var categoryFlag = flagSpec{Long: "category", Short: "c", Value: msgArgCategory}
+
+// คำสั่งทั้งหมด เรียงตามลำดับที่แสดงใน help
+var commands = []*commandSpec{
+	{Name: "upload", Aliases: thai("อัปโหลด", "อัพโหลด"), Flags: []flagSpec{categoryFlag},
+		Args: []argSpec{{Name: msgArgFilename, NonEmpty: true}}, ExtraArgs: uploadExtraArgs},
+	{Name: "open", Aliases: thai("เปิด"), Args: []argSpec{{Name: msgArgFilename, NonEmpty: true}}},
+	{Name: "list", Aliases: thai("รายการ"), Flags: []flagSpec{categoryFlag},
+		Args: []argSpec{{Name: msgArgListFilter, Optional: true, Repeated: true}}},
+	{Name: "rename", Aliases: thai("เปลี่ยนชื่อ"), Args: []argSpec{{Name: msgArgOldName, NonEmpty: true}, {Name: msgArgNewName, NonEmpty: true}}},
+	{Name: "delete", Aliases: thai("ลบ"), Args: []argSpec{{Name: msgArgFilename, NonEmpty: true}}},
+	{Name: "tag", Aliases: thai("แท็ก"), Args: []argSpec{{Name: msgArgFilename, NonEmpty: true}, {Name: msgArgTag, Repeated: true}}},
+	{Name: "untag", Aliases: thai("ลบแท็ก"), Args: []argSpec{{Name: msgArgFilename, NonEmpty: true}, {Name: msgArgTag, Repeated: true}}},
+	{Name: "share", Aliases: thai("แชร์"), Args: []argSpec{{Name: msgArgFilename, NonEmpty: true}, {Name: msgArgDuration, Optional: true}}},
+	{Name: "unshare", Aliases: thai("เลิกแชร์"), Args: []argSpec{{Name: msgArgFilename, NonEmpty: true}}},
+	{Name: "autosave", Aliases: thai("บันทึกอัตโนมัติ"), Subcommands: []*commandSpec{
+		{Name: "on", Aliases: thai("เปิด"), Flags: []flagSpec{categoryFlag},
+			Args: []argSpec{{Name: msgArgCategory, Optional: true, NonEmpty: true}}},
+		{Name: "off", Aliases: thai("ปิด")},
+		{Name: "status", Aliases: thai("สถานะ")},
+	}},
+	{Name: "quota", Aliases: thai("โควต้า", "พื้นที่")},
+	{Name: "lang", Aliases: thai("ภาษา"), Args: []argSpec{{Name: msgArgLanguage, Optional: true}}},
+	{Name: "admin", Admin: true, Subcommands: []*commandSpec{
+		{Name: "stats"},
+		{Name: "files", Args: []argSpec{{Name: msgArgUserID, NonEmpty: true}}},
+		{Name: "suspend", Args: []argSpec{{Name: msgArgUserID, NonEmpty: true}, {Name: msgArgReason, Optional: true, Rest: true}}},
+		{Name: "unsuspend", Args: []argSpec{{Name: msgArgUserID, NonEmpty: true}}},
+		{Name: "delete", Args: []argSpec{{Name: msgArgOwnerID, NonEmpty: true}, {Name: msgArgFilename, NonEmpty: true}}},
+		{Name: "quota", Args: []argSpec{{Name: msgArgOwnerID, NonEmpty: true}, {Name: msgArgQuotaBytes, Optional: true}, {Name: msgArgQuotaFiles, Optional: true}}},
+		{Name: "broadcast", Args: []argSpec{{Name: msgArgMessage, Rest: true}}},
+	}},
+	{Name: "audit", Admin: true, Args: []argSpec{{Name: msgArgUserID, Optional: true, NonEmpty: true}, {Name: msgArgSince, Optional: true}}},
+}
+
+// thai returns the Thai aliases of a command
+func thai(aliases ...string) map[string][]string {
+	return map[string][]string{langThai: aliases}
+}
+
+// uploadExtraArgs points the old "upload <category> <filename>" syntax to -c
+func uploadExtraArgs(args []string) *usageError {
+	if len(args) != 2 {
+		return nil
+	}
+	return newUsageError(msgCategoryFlag, "upload -c "+quoteArg(args[0])+" "+quoteArg(args[1]))
+}
+
+// quoteArg quotes s if it has to be quoted to be a single argument
+func quoteArg(s string) string {
+	if s == "" || strings.ContainsFunc(s, unicode.IsSpace) {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// command is a parsed text command
+type command struct {
+	Root  *commandSpec      // the command typed
+	Spec  *commandSpec      // the command, or the subcommand picked
+	Args  []string          // positional arguments
+	Flags map[string]string // flag values by long name
+
+	parents []*commandSpec // commands above a subcommand, for usage text
+}
+
+// Flag returns the value of a flag, or def if it was not given
+func (c command) Flag(name, def string) string {
+	if value, ok := c.Flags[name]; ok {
+		return value
+	}
+	return def
+}
+
+// usageError explains why a command's arguments were rejected. It wraps
+// errUsage so the audit trail records the command as invalid.
+type usageError struct {
+	key  msgKey
+	args []any
+}
+
+func newUsageError(key msgKey, args ...any) *usageError {
+	return &usageError{key: key, args: args}
+}
+
+func (e *usageError) Error() string {
+	return catalogText(langEnglish, e.key, e.args...)
+}
+
+func (e *usageError) Unwrap() error {
+	return errUsage
+}
+
+// parseCommand splits text into a command and its arguments. The command
+// name is looked up first, so Root is nil only for text that is not a
+// command; any error is a *usageError about that command's arguments.
+func parseCommand(text string) (command, error) {
+	tokens, tokenErr := tokenize(text)
+	if len(tokens) == 0 {
+		return command{}, nil
+	}
+	spec := lookupCommand(commands, tokens[0].Text)
+	if spec == nil {
+		return command{}, nil
+	}
+	cmd := command{Root: spec, Spec: spec, Flags: make(map[string]string)}
+	if tokenErr != nil {
+		return cmd, tokenErr
+	}
+	return cmd, cmd.parse(text, tokens[1:])
+}
+
+// lookupCommand finds a command by name or alias, ignoring case
+func lookupCommand(specs []*commandSpec, name string) *commandSpec {
+	name = strings.ToLower(name)
+	for _, spec := range specs {
+		if spec.Name == name {
+			return spec
+		}
+		for _, aliases := range spec.Aliases {
+			for _, alias := range aliases {
+				if strings.ToLower(alias) == name {
+					return spec
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parse fills in the subcommand, flags and arguments from the tokens after
+// the command name
+func (c *command) parse(text string, tokens []token) error {
+	if len(c.Spec.Subcommands) > 0 {
+		if len(tokens) == 0 {
+			return newUsageError(msgMissingArgs)
+		}
+		sub := lookupCommand(c.Spec.Subcommands, tokens[0].Text)
+		if sub == nil {
+			return newUsageError(msgUnknownOption, tokens[0].Text)
+		}
+		c.parents = append(c.parents, c.Spec)
+		c.Spec = sub
+		return c.parse(text, tokens[1:])
+	}
+
+	var positional []token
+	flagsDone := len(c.Spec.Flags) == 0
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if flagsDone || tok.Quoted || len(tok.Text) < 2 || tok.Text[0] != '-' {
+			positional = append(positional, tok)
+			continue
+		}
+		if tok.Text == "--" {
+			flagsDone = true // everything after -- is an argument
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(tok.Text, "=")
+		flag, ok := c.Spec.flag(name)
+		if !ok {
+			return newUsageError(msgUnknownOption, name)
+		}
+		if !hasValue {
+			if i+1 == len(tokens) {
+				return newUsageError(msgMissingFlagValue, name)
+			}
+			i++
+			value = tokens[i].Text
+		}
+		if value == "" {
+			return newUsageError(msgMissingFlagValue, name)
+		}
+		c.Flags[flag.Long] = value
+	}
+
+	return c.setArgs(text, positional)
+}
+
+// flag finds a flag by its long (--name) or short (-n) form, ignoring case
+func (s *commandSpec) flag(name string) (flagSpec, bool) {
+	name = strings.ToLower(name)
+	for _, flag := range s.Flags {
+		if name == "--"+flag.Long || (flag.Short != "" && name == "-"+flag.Short) {
+			return flag, true
+		}
+	}
+	return flagSpec{}, false
+}
+
+// setArgs checks the number of positional arguments against the spec
+func (c *command) setArgs(text string, positional []token) error {
+	specs := c.Spec.Args
+	required, unlimited := 0, false
+	for _, arg := range specs {
+		if !arg.Optional {
+			required++
+		}
+		unlimited = unlimited || arg.Repeated || arg.Rest
+	}
+	switch {
+	case len(positional) < required:
+		return newUsageError(msgMissingArgs)
+	case len(positional) > len(specs) && !unlimited:
+		if c.Spec.ExtraArgs != nil {
+			args := make([]string, len(positional))
+			for i, tok := range positional {
+				args[i] = tok.Text
+			}
+			if err := c.Spec.ExtraArgs(args); err != nil {
+				return err
+			}
+		}
+		return newUsageError(msgTooManyArgs)
+	}
+
+	for i, tok := range positional {
+		if i < len(specs) && specs[i].NonEmpty && tok.Text == "" {
+			return newUsageError(msgEmptyArg)
+		}
+		if i < len(specs) && specs[i].Rest {
+			c.Args = append(c.Args, strings.TrimSpace(text[tok.Pos:]))
+			break
+		}
+		c.Args = append(c.Args, tok.Text)
+	}
+	return nil
+}
+
+// token is one word of a command; Pos is its byte offset in the text
+type token struct {
+	Text   string
+	Quoted bool
+	Pos    int
+}
+
+// closingQuotes maps each opening quote to the quotes that may close it.
+// Phones often turn typed quotes into curly ones, so those count as well.
+var closingQuotes = map[rune]string{
+	'"':  `"”`,
+	'“':  `”"`,
+	'”':  `”"`,
+	'\'': `'’`,
+	'‘':  `’'`,
+	'’':  `’'`,
+}
+
+// tokenize splits text into words at whitespace. A word that starts with a
+// quote runs to the matching closing quote, so names may contain spaces:
+// rename "old name" "new name". So does a flag value, as in
+// --category="work stuff". Any other quote inside a word is an ordinary
+// character, as in it's. On a missing closing quote the words read so far are
+// returned with a *usageError.
+func tokenize(text string) ([]token, error) {
+	var tokens []token
+	var current *token
+	var closers string // closing quotes of the open quote, if any
+
+	for pos, r := range text {
+		switch {
+		case closers != "":
+			if strings.ContainsRune(closers, r) {
+				closers = ""
+			} else {
+				current.Text += string(r)
+			}
+		case unicode.IsSpace(r):
+			if current != nil {
+				tokens = append(tokens, *current)
+				current = nil
+			}
+		case current == nil:
+			current = &token{Pos: pos}
+			if quotes, ok := closingQuotes[r]; ok {
+				current.Quoted, closers = true, quotes
+			} else {
+				current.Text = string(r)
+			}
+		case isFlagValueQuote(current.Text, r):
+			closers = closingQuotes[r]
+		default:
+			current.Text += string(r)
+		}
+	}
+	if current != nil {
+		tokens = append(tokens, *current)
+	}
+	if closers != "" {
+		return tokens, newUsageError(msgUnclosedQuote)
+	}
+	return tokens, nil
+}
+
+// isFlagValueQuote reports whether r opens a quoted value after --flag=
+func isFlagValueQuote(word string, r rune) bool {
+	_, ok := closingQuotes[r]
+	return ok && strings.HasPrefix(word, "-") && strings.HasSuffix(word, "=")
+}
+
+// usageReply tells the user what was wrong with a command and how to use it
+func usageReply(ctx context.Context, cmd command, err error) string {
+	prefix := ""
+	for _, parent := range cmd.parents {
+		prefix += displayName(ctx, parent) + " "
+	}
+	text := t(ctx, msgUsageLine, strings.Join(usageLines(ctx, cmd.Spec, prefix), "\n"))
+	if uerr, ok := err.(*usageError); ok {
+		text = t(ctx, msgInvalidValue, catalogText(language(ctx), uerr.key, uerr.args...)) + "\n" + text
+	}
+	return text
+}
+
+// usageLines renders the syntax of a command, one line per subcommand
+func usageLines(ctx context.Context, spec *commandSpec, parents string) []string {
+	name := displayName(ctx, spec)
+	if len(spec.Subcommands) > 0 {
+		var lines []string
+		for _, sub := range spec.Subcommands {
+			lines = append(lines, usageLines(ctx, sub, parents+name+" ")...)
+		}
+		return lines
+	}
+
+	parts := []string{parents + name}
+	for _, flag := range spec.Flags {
+		parts = append(parts, fmt.Sprintf("[-%s|--%s <%s>]", flag.Short, flag.Long, t(ctx, flag.Value)))
+	}
+	for _, arg := range spec.Args {
+		placeholder := t(ctx, arg.Name)
+		if arg.Repeated {
+			placeholder += "..."
+		}
+		if arg.Optional {
+			parts = append(parts, "["+placeholder+"]")
+		} else {
+			parts = append(parts, "<"+placeholder+">")
+		}
+	}
+	return []string{strings.Join(parts, " ")}
+}
+
+// displayName is a command's name followed by its aliases in the reader's
+// language, e.g. upload/อัปโหลด
+func displayName(ctx context.Context, spec *commandSpec) string {
+	return strings.Join(append([]string{spec.Name}, spec.Aliases[language(ctx)]...), "/")
+}
+
+// helpText lists the commands the sender may use
+func helpText(ctx context.Context, userID string) string {
+	lines := []string{t(ctx, msgCommands)}
+	for _, spec := range commands {
+		if spec.Admin && !isAdmin(userID) {
+			continue
+		}
+		lines = append(lines, usageLines(ctx, spec, "")...)
+	}
+	return strings.Join(lines, "\n")
+}
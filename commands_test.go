@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    []token
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"   ", nil, false},
+		{"open report", []token{{"open", false, 0}, {"report", false, 5}}, false},
+		{`  a  "b c" d`, []token{{"a", false, 2}, {"b c", true, 5}, {"d", false, 11}}, false},
+		{`rename it's new`, []token{{"rename", false, 0}, {"it's", false, 7}, {"new", false, 12}}, false},
+		{`open ""`, []token{{"open", false, 0}, {"", true, 5}}, false},
+		{`open "a"b`, []token{{"open", false, 0}, {"ab", true, 5}}, false},
+		{`open “my report”`, []token{{"open", false, 0}, {"my report", true, 5}}, false},
+		{`open ”my report”`, []token{{"open", false, 0}, {"my report", true, 5}}, false},
+		{`open ‘my report’`, []token{{"open", false, 0}, {"my report", true, 5}}, false},
+		{`open "my report”`, []token{{"open", false, 0}, {"my report", true, 5}}, false},
+		{`เปิด "รายงาน ปี"`, []token{{"เปิด", false, 0}, {"รายงาน ปี", true, 13}}, false},
+		{`upload --category="work stuff" a`, []token{{"upload", false, 0}, {"--category=work stuff", false, 7}, {"a", false, 31}}, false},
+		{`upload --category=work"s a`, []token{{"upload", false, 0}, {`--category=work"s`, false, 7}, {"a", false, 25}}, false},
+		{`open "my report`, []token{{"open", false, 0}, {"my report", true, 5}}, true},
+	}
+	for _, tt := range tests {
+		got, err := tokenize(tt.text)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("tokenize(%q) error = %v, want error %v", tt.text, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	// noError marks cases that parse cleanly
+	const noError msgKey = -1
+
+	tests := []struct {
+		text  string
+		root  string // "" when the text is not a command
+		spec  string
+		args  []string
+		flags map[string]string
+		err   msgKey
+	}{
+		{"", "", "", nil, nil, noError},
+		{"hello there", "", "", nil, nil, noError},
+		{`hello "there`, "", "", nil, nil, noError},
+
+		{`upload report`, "upload", "upload", []string{"report"}, nil, noError},
+		{`UPLOAD "my report"`, "upload", "upload", []string{"my report"}, nil, noError},
+		{`upload -c work "my report"`, "upload", "upload", []string{"my report"}, map[string]string{"category": "work"}, noError},
+		{`upload "my report" --category work`, "upload", "upload", []string{"my report"}, map[string]string{"category": "work"}, noError},
+		{`upload --category="work stuff" report`, "upload", "upload", []string{"report"}, map[string]string{"category": "work stuff"}, noError},
+		{`upload --CATEGORY work report`, "upload", "upload", []string{"report"}, map[string]string{"category": "work"}, noError},
+		{`upload -- -c`, "upload", "upload", []string{"-c"}, nil, noError},
+		{`upload "-c"`, "upload", "upload", []string{"-c"}, nil, noError},
+		{`upload -`, "upload", "upload", []string{"-"}, nil, noError},
+		{`อัปโหลด -c งาน รายงาน`, "upload", "upload", []string{"รายงาน"}, map[string]string{"category": "งาน"}, noError},
+		{`upload`, "upload", "upload", nil, nil, msgMissingArgs},
+		{`upload report -c`, "upload", "upload", nil, nil, msgMissingFlagValue},
+		{`upload -x report`, "upload", "upload", nil, nil, msgUnknownOption},
+		{`upload work report`, "upload", "upload", nil, nil, msgCategoryFlag},
+		{`upload work my report`, "upload", "upload", nil, nil, msgTooManyArgs},
+		{`upload "my report`, "upload", "upload", nil, nil, msgUnclosedQuote},
+		{`upload ""`, "upload", "upload", nil, nil, msgEmptyArg},
+		{`upload -c "" report`, "upload", "upload", nil, nil, msgMissingFlagValue},
+		{`upload --category= report`, "upload", "upload", nil, nil, msgMissingFlagValue},
+
+		{`open a b`, "open", "open", nil, nil, msgTooManyArgs},
+		{`open -c`, "open", "open", []string{"-c"}, nil, noError},
+		{`rename “old name” ”new name”`, "rename", "rename", []string{"old name", "new name"}, nil, noError},
+		{`rename report ""`, "rename", "rename", nil, nil, msgEmptyArg},
+		{`open ""`, "open", "open", nil, nil, msgEmptyArg},
+		{`share "" 7d`, "share", "share", nil, nil, msgEmptyArg},
+		{`tag "" a`, "tag", "tag", nil, nil, msgEmptyArg},
+		{`tag report ""`, "tag", "tag", []string{"report", ""}, nil, noError},
+		{`tag report a b`, "tag", "tag", []string{"report", "a", "b"}, nil, noError},
+		{`tag report`, "tag", "tag", nil, nil, msgMissingArgs},
+		{`list`, "list", "list", nil, nil, noError},
+		{`list #a #b`, "list", "list", []string{"#a", "#b"}, nil, noError},
+		{`list -c "work stuff"`, "list", "list", nil, map[string]string{"category": "work stuff"}, noError},
+		{`share report 7d`, "share", "share", []string{"report", "7d"}, nil, noError},
+
+		{`autosave on -c photos`, "autosave", "on", nil, map[string]string{"category": "photos"}, noError},
+		{`autosave ON photos`, "autosave", "on", []string{"photos"}, nil, noError},
+		{`บันทึกอัตโนมัติ ปิด`, "autosave", "off", nil, nil, noError},
+		{`autosave`, "autosave", "autosave", nil, nil, msgMissingArgs},
+		{`autosave maybe`, "autosave", "autosave", nil, nil, msgUnknownOption},
+		{`autosave off now`, "autosave", "off", nil, nil, msgTooManyArgs},
+		{`autosave on ""`, "autosave", "on", nil, nil, msgEmptyArg},
+
+		{`admin broadcast  Hello   "world" `, "admin", "broadcast", []string{`Hello   "world"`}, nil, noError},
+		{`admin suspend U1 spamming  links`, "admin", "suspend", []string{"U1", "spamming  links"}, nil, noError},
+		{`admin suspend U1`, "admin", "suspend", []string{"U1"}, nil, noError},
+		{`admin quota G1 reset`, "admin", "quota", []string{"G1", "reset"}, nil, noError},
+		{`admin quota G1 1 2 3`, "admin", "quota", nil, nil, msgTooManyArgs},
+		{`audit`, "audit", "audit", nil, nil, noError},
+	}
+	for _, tt := range tests {
+		cmd, err := parseCommand(tt.text)
+
+		var root, spec string
+		if cmd.Root != nil {
+			root, spec = cmd.Root.Name, cmd.Spec.Name
+		}
+		if root != tt.root || spec != tt.spec {
+			t.Errorf("parseCommand(%q) command = %q/%q, want %q/%q", tt.text, root, spec, tt.root, tt.spec)
+			continue
+		}
+
+		if tt.err != noError {
+			var uerr *usageError
+			if !errors.As(err, &uerr) || uerr.key != tt.err {
+				t.Errorf("parseCommand(%q) error = %v, want message %d", tt.text, err, tt.err)
+			}
+			if !errors.Is(err, errUsage) {
+				t.Errorf("parseCommand(%q) error %v does not wrap errUsage", tt.text, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCommand(%q) unexpected error: %v", tt.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(cmd.Args, tt.args) {
+			t.Errorf("parseCommand(%q) args = %q, want %q", tt.text, cmd.Args, tt.args)
+		}
+		if len(cmd.Flags) != len(tt.flags) || (len(tt.flags) > 0 && !reflect.DeepEqual(cmd.Flags, tt.flags)) {
+			t.Errorf("parseCommand(%q) flags = %v, want %v", tt.text, cmd.Flags, tt.flags)
+		}
+	}
+}
+
+func TestUploadCategoryHint(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{`upload work report`, "upload -c work report"},
+		{`upload "work stuff" "my report"`, `upload -c "work stuff" "my report"`},
+	}
+	for _, tt := range tests {
+		_, err := parseCommand(tt.text)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseCommand(%q) error = %v, want a hint with %q", tt.text, err, tt.want)
+		}
+	}
+}
@@ -6,14 +6,135 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 )
 
-type Line01 struct {
-	ID          int32
+type AuditLog struct {
+	ID        int64
+	UserID    string
+	SourceID  string
+	Command   string
+	Target    sql.NullString
+	Result    string
+	Error     sql.NullString
+	CreatedAt time.Time
+}
+
+type AutosaveSetting struct {
+	OwnerID   string
+	Enabled   bool
+	Category  string
+	UpdatedBy sql.NullString
+	UpdatedAt time.Time
+}
+
+type Category struct {
+	ID        int64
+	OwnerID   string
+	Name      string
+	CreatedAt time.Time
+}
+
+type File struct {
+	ID         int64
+	OwnerID    string
+	Folder     string
+	Name       string
+	Extension  string
+	MimeType   sql.NullString
+	Size       sql.NullInt64
+	CategoryID sql.NullInt64
+	ObjectID   sql.NullInt64
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UploadedBy sql.NullString
+	EventID    sql.NullString
+}
+
+type FileTag struct {
+	FileID int64
+	TagID  int64
+}
+
+type Object struct {
+	ID        int64
+	ObjectKey string
+	Sha256    sql.NullString
+	Size      sql.NullInt64
+	MimeType  sql.NullString
+	Status    string
+	CreatedAt time.Time
+}
+
+type PendingEvent struct {
+	ID         int64
+	EventID    string
+	Payload    json.RawMessage
+	Redelivery bool
+	SavedAt    time.Time
+}
+
+type Quota struct {
+	OwnerID   string
+	MaxBytes  sql.NullInt64
+	MaxFiles  sql.NullInt64
+	UpdatedBy sql.NullString
+	UpdatedAt time.Time
+}
+
+type RateLimitBucket struct {
+	BucketKey string
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+type Share struct {
+	ID             string
+	FileID         int64
+	UserID         string
+	ExpiresAt      time.Time
+	RevokedAt      sql.NullTime
+	AccessCount    int64
+	LastAccessedAt sql.NullTime
+	CreatedAt      time.Time
+}
+
+type StorageUsage struct {
+	OwnerID   string
+	Bytes     int64
+	Files     int64
+	UpdatedAt time.Time
+}
+
+type SuspendedUser struct {
 	UserID      string
-	FileName    string
-	FileContent sql.NullString
-	CreatedAt   time.Time
-	Theme       sql.NullString
+	Reason      sql.NullString
+	SuspendedBy string
+	SuspendedAt time.Time
+}
+
+type Tag struct {
+	ID      int64
+	OwnerID string
+	Name    string
+}
+
+type UploadSession struct {
+	SessionKey string
+	Filename   string
+	SavedAt    time.Time
+}
+
+type UserLanguage struct {
+	UserID    string
+	Language  string
+	UpdatedAt time.Time
+}
+
+type WebhookEvent struct {
+	EventID     string
+	ClaimedAt   time.Time
+	CompletedAt sql.NullTime
+	Attempts    int32
 }
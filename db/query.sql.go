@@ -8,70 +8,604 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"time"
+
+	"github.com/lib/pq"
 )
 
-const deleteFile = `-- name: DeleteFile :exec
-DELETE FROM line_01 WHERE file_name = $1
+const addFileTag = `-- name: AddFileTag :exec
+INSERT INTO file_tags (file_id, tag_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING
+`
+
+type AddFileTagParams struct {
+	FileID int64
+	TagID  int64
+}
+
+func (q *Queries) AddFileTag(ctx context.Context, arg AddFileTagParams) error {
+	_, err := q.db.ExecContext(ctx, addFileTag, arg.FileID, arg.TagID)
+	return err
+}
+
+const attachFileObject = `-- name: AttachFileObject :execrows
+UPDATE files
+SET object_id = $1, extension = $2, mime_type = $3, size = $4, event_id = $5, updated_at = now()
+WHERE owner_id = $6 AND folder = '/' AND name = $7 AND (object_id IS NULL OR event_id = $5)
+`
+
+type AttachFileObjectParams struct {
+	ObjectID  sql.NullInt64
+	Extension string
+	MimeType  sql.NullString
+	Size      sql.NullInt64
+	EventID   sql.NullString
+	OwnerID   string
+	Name      string
+}
+
+func (q *Queries) AttachFileObject(ctx context.Context, arg AttachFileObjectParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, attachFileObject,
+		arg.ObjectID,
+		arg.Extension,
+		arg.MimeType,
+		arg.Size,
+		arg.EventID,
+		arg.OwnerID,
+		arg.Name,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const claimWebhookEvent = `-- name: ClaimWebhookEvent :execrows
+INSERT INTO webhook_events (event_id) VALUES ($1)
+ON CONFLICT (event_id) DO UPDATE
+SET claimed_at = now(), attempts = webhook_events.attempts + 1
+WHERE webhook_events.completed_at IS NULL AND webhook_events.claimed_at < $2
 `
 
-func (q *Queries) DeleteFile(ctx context.Context, fileName string) error {
-	_, err := q.db.ExecContext(ctx, deleteFile, fileName)
+type ClaimWebhookEventParams struct {
+	EventID     string
+	StaleBefore time.Time
+}
+
+func (q *Queries) ClaimWebhookEvent(ctx context.Context, arg ClaimWebhookEventParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, claimWebhookEvent, arg.EventID, arg.StaleBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const completeWebhookEvent = `-- name: CompleteWebhookEvent :exec
+UPDATE webhook_events SET completed_at = now() WHERE event_id = $1
+`
+
+func (q *Queries) CompleteWebhookEvent(ctx context.Context, eventID string) error {
+	_, err := q.db.ExecContext(ctx, completeWebhookEvent, eventID)
 	return err
 }
 
-const getFileURL = `-- name: GetFileURL :one
-SELECT file_content FROM line_01 WHERE file_name = $1
+const countObjectReferences = `-- name: CountObjectReferences :one
+SELECT COUNT(*) FROM files WHERE object_id = $1
 `
 
-func (q *Queries) GetFileURL(ctx context.Context, fileName string) (sql.NullString, error) {
-	row := q.db.QueryRowContext(ctx, getFileURL, fileName)
-	var file_content sql.NullString
-	err := row.Scan(&file_content)
-	return file_content, err
+func (q *Queries) CountObjectReferences(ctx context.Context, objectID sql.NullInt64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countObjectReferences, objectID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
 }
 
-const insertFileMetadata = `-- name: InsertFileMetadata :exec
-INSERT INTO line_01 (user_id, file_name, file_content, created_at, theme) 
+const createPendingFile = `-- name: CreatePendingFile :execrows
+INSERT INTO files (owner_id, name, category_id, uploaded_by, event_id)
 VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (owner_id, folder, name) DO UPDATE
+SET category_id = EXCLUDED.category_id, uploaded_by = EXCLUDED.uploaded_by, updated_at = now()
+WHERE files.object_id IS NULL OR files.event_id = EXCLUDED.event_id
 `
 
-type InsertFileMetadataParams struct {
-	UserID      string
-	FileName    string
-	FileContent sql.NullString
-	CreatedAt   time.Time
-	Theme       sql.NullString
+type CreatePendingFileParams struct {
+	OwnerID    string
+	Name       string
+	CategoryID sql.NullInt64
+	UploadedBy sql.NullString
+	EventID    sql.NullString
+}
+
+func (q *Queries) CreatePendingFile(ctx context.Context, arg CreatePendingFileParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, createPendingFile,
+		arg.OwnerID,
+		arg.Name,
+		arg.CategoryID,
+		arg.UploadedBy,
+		arg.EventID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const createShare = `-- name: CreateShare :exec
+INSERT INTO shares (id, file_id, user_id, expires_at)
+VALUES ($1, $2, $3, $4)
+`
+
+type CreateShareParams struct {
+	ID        string
+	FileID    int64
+	UserID    string
+	ExpiresAt time.Time
 }
 
-func (q *Queries) InsertFileMetadata(ctx context.Context, arg InsertFileMetadataParams) error {
-	_, err := q.db.ExecContext(ctx, insertFileMetadata,
+func (q *Queries) CreateShare(ctx context.Context, arg CreateShareParams) error {
+	_, err := q.db.ExecContext(ctx, createShare,
+		arg.ID,
+		arg.FileID,
 		arg.UserID,
-		arg.FileName,
-		arg.FileContent,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const deleteFile = `-- name: DeleteFile :exec
+DELETE FROM files WHERE owner_id = $1 AND folder = '/' AND name = $2
+`
+
+type DeleteFileParams struct {
+	OwnerID string
+	Name    string
+}
+
+func (q *Queries) DeleteFile(ctx context.Context, arg DeleteFileParams) error {
+	_, err := q.db.ExecContext(ctx, deleteFile, arg.OwnerID, arg.Name)
+	return err
+}
+
+const deleteObject = `-- name: DeleteObject :exec
+DELETE FROM objects WHERE id = $1
+`
+
+func (q *Queries) DeleteObject(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteObject, id)
+	return err
+}
+
+const deletePendingFile = `-- name: DeletePendingFile :exec
+DELETE FROM files
+WHERE owner_id = $1 AND folder = '/' AND name = $2 AND uploaded_by IS NOT DISTINCT FROM $3 AND object_id IS NULL
+`
+
+type DeletePendingFileParams struct {
+	OwnerID    string
+	Name       string
+	UploadedBy sql.NullString
+}
+
+func (q *Queries) DeletePendingFile(ctx context.Context, arg DeletePendingFileParams) error {
+	_, err := q.db.ExecContext(ctx, deletePendingFile, arg.OwnerID, arg.Name, arg.UploadedBy)
+	return err
+}
+
+const deleteQuota = `-- name: DeleteQuota :execrows
+DELETE FROM quotas WHERE owner_id = $1
+`
+
+func (q *Queries) DeleteQuota(ctx context.Context, ownerID string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteQuota, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const ensureUsage = `-- name: EnsureUsage :exec
+INSERT INTO storage_usage (owner_id) VALUES ($1)
+ON CONFLICT (owner_id) DO NOTHING
+`
+
+func (q *Queries) EnsureUsage(ctx context.Context, ownerID string) error {
+	_, err := q.db.ExecContext(ctx, ensureUsage, ownerID)
+	return err
+}
+
+const exportAuditLog = `-- name: ExportAuditLog :many
+SELECT id, user_id, source_id, command, target, result, error, created_at FROM audit_log
+WHERE ($1::text IS NULL OR user_id = $1)
+  AND created_at >= $2
+ORDER BY created_at, id
+`
+
+type ExportAuditLogParams struct {
+	UserID sql.NullString
+	Since  time.Time
+}
+
+func (q *Queries) ExportAuditLog(ctx context.Context, arg ExportAuditLogParams) ([]AuditLog, error) {
+	rows, err := q.db.QueryContext(ctx, exportAuditLog, arg.UserID, arg.Since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditLog
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.SourceID,
+			&i.Command,
+			&i.Target,
+			&i.Result,
+			&i.Error,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAutosave = `-- name: GetAutosave :one
+SELECT owner_id, enabled, category, updated_by, updated_at FROM autosave_settings WHERE owner_id = $1
+`
+
+func (q *Queries) GetAutosave(ctx context.Context, ownerID string) (AutosaveSetting, error) {
+	row := q.db.QueryRowContext(ctx, getAutosave, ownerID)
+	var i AutosaveSetting
+	err := row.Scan(
+		&i.OwnerID,
+		&i.Enabled,
+		&i.Category,
+		&i.UpdatedBy,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getEventFileObject = `-- name: GetEventFileObject :one
+SELECT o.object_key FROM files f
+JOIN objects o ON o.id = f.object_id
+WHERE f.owner_id = $1 AND f.folder = '/' AND f.name = $2 AND f.event_id = $3
+`
+
+type GetEventFileObjectParams struct {
+	OwnerID string
+	Name    string
+	EventID sql.NullString
+}
+
+func (q *Queries) GetEventFileObject(ctx context.Context, arg GetEventFileObjectParams) (string, error) {
+	row := q.db.QueryRowContext(ctx, getEventFileObject, arg.OwnerID, arg.Name, arg.EventID)
+	var object_key string
+	err := row.Scan(&object_key)
+	return object_key, err
+}
+
+const getFileObject = `-- name: GetFileObject :one
+SELECT f.id, f.extension, f.mime_type, f.size, f.object_id, o.object_key, o.sha256, o.status
+FROM files f
+LEFT JOIN objects o ON o.id = f.object_id
+WHERE f.owner_id = $1 AND f.folder = '/' AND f.name = $2
+`
+
+type GetFileObjectParams struct {
+	OwnerID string
+	Name    string
+}
+
+type GetFileObjectRow struct {
+	ID        int64
+	Extension string
+	MimeType  sql.NullString
+	Size      sql.NullInt64
+	ObjectID  sql.NullInt64
+	ObjectKey sql.NullString
+	Sha256    sql.NullString
+	Status    sql.NullString
+}
+
+func (q *Queries) GetFileObject(ctx context.Context, arg GetFileObjectParams) (GetFileObjectRow, error) {
+	row := q.db.QueryRowContext(ctx, getFileObject, arg.OwnerID, arg.Name)
+	var i GetFileObjectRow
+	err := row.Scan(
+		&i.ID,
+		&i.Extension,
+		&i.MimeType,
+		&i.Size,
+		&i.ObjectID,
+		&i.ObjectKey,
+		&i.Sha256,
+		&i.Status,
+	)
+	return i, err
+}
+
+const getGlobalStats = `-- name: GetGlobalStats :one
+SELECT (SELECT COUNT(DISTINCT owner_id) FROM files) AS owners,
+       (SELECT COUNT(*) FROM files WHERE object_id IS NOT NULL) AS files,
+       (SELECT COUNT(*) FROM objects) AS objects,
+       (SELECT COALESCE(SUM(size), 0) FROM objects)::bigint AS stored_bytes,
+       (SELECT COUNT(*) FROM shares WHERE revoked_at IS NULL AND expires_at > now()) AS active_shares,
+       (SELECT COUNT(*) FROM suspended_users) AS suspended_users
+`
+
+type GetGlobalStatsRow struct {
+	Owners         int64
+	Files          int64
+	Objects        int64
+	StoredBytes    int64
+	ActiveShares   int64
+	SuspendedUsers int64
+}
+
+func (q *Queries) GetGlobalStats(ctx context.Context) (GetGlobalStatsRow, error) {
+	row := q.db.QueryRowContext(ctx, getGlobalStats)
+	var i GetGlobalStatsRow
+	err := row.Scan(
+		&i.Owners,
+		&i.Files,
+		&i.Objects,
+		&i.StoredBytes,
+		&i.ActiveShares,
+		&i.SuspendedUsers,
+	)
+	return i, err
+}
+
+const getObjectByHash = `-- name: GetObjectByHash :one
+SELECT id, object_key, status FROM objects WHERE sha256 = $1
+`
+
+type GetObjectByHashRow struct {
+	ID        int64
+	ObjectKey string
+	Status    string
+}
+
+func (q *Queries) GetObjectByHash(ctx context.Context, sha256 sql.NullString) (GetObjectByHashRow, error) {
+	row := q.db.QueryRowContext(ctx, getObjectByHash, sha256)
+	var i GetObjectByHashRow
+	err := row.Scan(&i.ID, &i.ObjectKey, &i.Status)
+	return i, err
+}
+
+const getQuota = `-- name: GetQuota :one
+SELECT owner_id, max_bytes, max_files, updated_by, updated_at FROM quotas WHERE owner_id = $1
+`
+
+func (q *Queries) GetQuota(ctx context.Context, ownerID string) (Quota, error) {
+	row := q.db.QueryRowContext(ctx, getQuota, ownerID)
+	var i Quota
+	err := row.Scan(
+		&i.OwnerID,
+		&i.MaxBytes,
+		&i.MaxFiles,
+		&i.UpdatedBy,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getShare = `-- name: GetShare :one
+SELECT o.object_key, f.name, f.extension, f.mime_type
+FROM shares s
+JOIN files f ON f.id = s.file_id
+JOIN objects o ON o.id = f.object_id
+WHERE s.id = $1 AND s.revoked_at IS NULL AND s.expires_at > now() AND o.status <> 'broken'
+`
+
+type GetShareRow struct {
+	ObjectKey string
+	Name      string
+	Extension string
+	MimeType  sql.NullString
+}
+
+func (q *Queries) GetShare(ctx context.Context, id string) (GetShareRow, error) {
+	row := q.db.QueryRowContext(ctx, getShare, id)
+	var i GetShareRow
+	err := row.Scan(
+		&i.ObjectKey,
+		&i.Name,
+		&i.Extension,
+		&i.MimeType,
+	)
+	return i, err
+}
+
+const getSuspension = `-- name: GetSuspension :one
+SELECT user_id, reason, suspended_by, suspended_at FROM suspended_users WHERE user_id = $1
+`
+
+func (q *Queries) GetSuspension(ctx context.Context, userID string) (SuspendedUser, error) {
+	row := q.db.QueryRowContext(ctx, getSuspension, userID)
+	var i SuspendedUser
+	err := row.Scan(
+		&i.UserID,
+		&i.Reason,
+		&i.SuspendedBy,
+		&i.SuspendedAt,
+	)
+	return i, err
+}
+
+const getUsage = `-- name: GetUsage :one
+SELECT bytes, files FROM storage_usage WHERE owner_id = $1
+`
+
+type GetUsageRow struct {
+	Bytes int64
+	Files int64
+}
+
+func (q *Queries) GetUsage(ctx context.Context, ownerID string) (GetUsageRow, error) {
+	row := q.db.QueryRowContext(ctx, getUsage, ownerID)
+	var i GetUsageRow
+	err := row.Scan(&i.Bytes, &i.Files)
+	return i, err
+}
+
+const getUserLanguage = `-- name: GetUserLanguage :one
+SELECT language FROM user_languages WHERE user_id = $1
+`
+
+func (q *Queries) GetUserLanguage(ctx context.Context, userID string) (string, error) {
+	row := q.db.QueryRowContext(ctx, getUserLanguage, userID)
+	var language string
+	err := row.Scan(&language)
+	return language, err
+}
+
+const initUserLanguage = `-- name: InitUserLanguage :exec
+INSERT INTO user_languages (user_id, language) VALUES ($1, $2)
+ON CONFLICT (user_id) DO NOTHING
+`
+
+type InitUserLanguageParams struct {
+	UserID   string
+	Language string
+}
+
+func (q *Queries) InitUserLanguage(ctx context.Context, arg InitUserLanguageParams) error {
+	_, err := q.db.ExecContext(ctx, initUserLanguage, arg.UserID, arg.Language)
+	return err
+}
+
+const insertAdoptedFile = `-- name: InsertAdoptedFile :execrows
+INSERT INTO files (owner_id, name, extension, mime_type, size, category_id, object_id, created_at, uploaded_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $1)
+ON CONFLICT (owner_id, folder, name) DO NOTHING
+`
+
+type InsertAdoptedFileParams struct {
+	OwnerID    string
+	Name       string
+	Extension  string
+	MimeType   sql.NullString
+	Size       sql.NullInt64
+	CategoryID sql.NullInt64
+	ObjectID   sql.NullInt64
+	CreatedAt  time.Time
+}
+
+func (q *Queries) InsertAdoptedFile(ctx context.Context, arg InsertAdoptedFileParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, insertAdoptedFile,
+		arg.OwnerID,
+		arg.Name,
+		arg.Extension,
+		arg.MimeType,
+		arg.Size,
+		arg.CategoryID,
+		arg.ObjectID,
 		arg.CreatedAt,
-		arg.Theme,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const insertAuditLog = `-- name: InsertAuditLog :exec
+INSERT INTO audit_log (user_id, source_id, command, target, result, error)
+VALUES ($1, $2, $3, $4, $5, $6)
+`
+
+type InsertAuditLogParams struct {
+	UserID   string
+	SourceID string
+	Command  string
+	Target   sql.NullString
+	Result   string
+	Error    sql.NullString
+}
+
+func (q *Queries) InsertAuditLog(ctx context.Context, arg InsertAuditLogParams) error {
+	_, err := q.db.ExecContext(ctx, insertAuditLog,
+		arg.UserID,
+		arg.SourceID,
+		arg.Command,
+		arg.Target,
+		arg.Result,
+		arg.Error,
 	)
 	return err
 }
 
-const listAllCategories = `-- name: ListAllCategories :many
-SELECT DISTINCT theme FROM line_01
+const insertObject = `-- name: InsertObject :one
+INSERT INTO objects (object_key, sha256, size, mime_type)
+VALUES ($1, $2, $3, $4)
+RETURNING id
 `
 
-func (q *Queries) ListAllCategories(ctx context.Context) ([]sql.NullString, error) {
-	rows, err := q.db.QueryContext(ctx, listAllCategories)
+type InsertObjectParams struct {
+	ObjectKey string
+	Sha256    sql.NullString
+	Size      sql.NullInt64
+	MimeType  sql.NullString
+}
+
+func (q *Queries) InsertObject(ctx context.Context, arg InsertObjectParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, insertObject,
+		arg.ObjectKey,
+		arg.Sha256,
+		arg.Size,
+		arg.MimeType,
+	)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const listAuditLog = `-- name: ListAuditLog :many
+SELECT id, user_id, source_id, command, target, result, error, created_at FROM audit_log
+WHERE ($1::text IS NULL OR user_id = $1)
+  AND created_at >= $2
+ORDER BY created_at DESC, id DESC
+LIMIT $3
+`
+
+type ListAuditLogParams struct {
+	UserID  sql.NullString
+	Since   time.Time
+	MaxRows int32
+}
+
+func (q *Queries) ListAuditLog(ctx context.Context, arg ListAuditLogParams) ([]AuditLog, error) {
+	rows, err := q.db.QueryContext(ctx, listAuditLog, arg.UserID, arg.Since, arg.MaxRows)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []sql.NullString
+	var items []AuditLog
 	for rows.Next() {
-		var theme sql.NullString
-		if err := rows.Scan(&theme); err != nil {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.SourceID,
+			&i.Command,
+			&i.Target,
+			&i.Result,
+			&i.Error,
+			&i.CreatedAt,
+		); err != nil {
 			return nil, err
 		}
-		items = append(items, theme)
+		items = append(items, i)
 	}
 	if err := rows.Close(); err != nil {
 		return nil, err
@@ -82,23 +616,231 @@ func (q *Queries) ListAllCategories(ctx context.Context) ([]sql.NullString, erro
 	return items, nil
 }
 
-const listFilesInCategory = `-- name: ListFilesInCategory :many
-SELECT file_name FROM line_01 WHERE theme = $1
+const listCategories = `-- name: ListCategories :many
+SELECT c.name FROM categories c
+WHERE c.owner_id = $1 AND EXISTS (SELECT 1 FROM files f WHERE f.category_id = c.id AND f.object_id IS NOT NULL)
+ORDER BY c.name
+`
+
+func (q *Queries) ListCategories(ctx context.Context, ownerID string) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listCategories, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		items = append(items, name)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDuplicateFiles = `-- name: ListDuplicateFiles :many
+SELECT name FROM files
+WHERE owner_id = $1 AND object_id = $2 AND name <> $3
+ORDER BY name
 `
 
-func (q *Queries) ListFilesInCategory(ctx context.Context, theme sql.NullString) ([]string, error) {
-	rows, err := q.db.QueryContext(ctx, listFilesInCategory, theme)
+type ListDuplicateFilesParams struct {
+	OwnerID     string
+	ObjectID    sql.NullInt64
+	ExcludeName string
+}
+
+func (q *Queries) ListDuplicateFiles(ctx context.Context, arg ListDuplicateFilesParams) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listDuplicateFiles, arg.OwnerID, arg.ObjectID, arg.ExcludeName)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var items []string
 	for rows.Next() {
-		var file_name string
-		if err := rows.Scan(&file_name); err != nil {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		items = append(items, name)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFilesInCategory = `-- name: ListFilesInCategory :many
+SELECT f.name,
+       ARRAY(SELECT t.name FROM file_tags ft JOIN tags t ON t.id = ft.tag_id
+             WHERE ft.file_id = f.id ORDER BY t.name)::text[] AS tags
+FROM files f
+JOIN categories c ON c.id = f.category_id
+WHERE f.owner_id = $1 AND c.name = $2 AND f.object_id IS NOT NULL
+ORDER BY f.name
+`
+
+type ListFilesInCategoryParams struct {
+	OwnerID  string
+	Category string
+}
+
+type ListFilesInCategoryRow struct {
+	Name string
+	Tags []string
+}
+
+func (q *Queries) ListFilesInCategory(ctx context.Context, arg ListFilesInCategoryParams) ([]ListFilesInCategoryRow, error) {
+	rows, err := q.db.QueryContext(ctx, listFilesInCategory, arg.OwnerID, arg.Category)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListFilesInCategoryRow
+	for rows.Next() {
+		var i ListFilesInCategoryRow
+		if err := rows.Scan(&i.Name, pq.Array(&i.Tags)); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFilesWithTags = `-- name: ListFilesWithTags :many
+SELECT f.name,
+       ARRAY(SELECT t.name FROM file_tags ft JOIN tags t ON t.id = ft.tag_id
+             WHERE ft.file_id = f.id ORDER BY t.name)::text[] AS tags
+FROM files f
+WHERE f.owner_id = $1 AND f.object_id IS NOT NULL
+  AND (SELECT COUNT(*) FROM file_tags ft JOIN tags t ON t.id = ft.tag_id
+       WHERE ft.file_id = f.id AND t.name = ANY($2::text[])) = cardinality($2::text[])
+ORDER BY f.name
+`
+
+type ListFilesWithTagsParams struct {
+	OwnerID string
+	Tags    []string
+}
+
+type ListFilesWithTagsRow struct {
+	Name string
+	Tags []string
+}
+
+func (q *Queries) ListFilesWithTags(ctx context.Context, arg ListFilesWithTagsParams) ([]ListFilesWithTagsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listFilesWithTags, arg.OwnerID, pq.Array(arg.Tags))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListFilesWithTagsRow
+	for rows.Next() {
+		var i ListFilesWithTagsRow
+		if err := rows.Scan(&i.Name, pq.Array(&i.Tags)); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listObjects = `-- name: ListObjects :many
+SELECT o.id, o.object_key, o.sha256, o.size, o.status, COUNT(f.id) AS file_count
+FROM objects o
+LEFT JOIN files f ON f.object_id = o.id
+GROUP BY o.id
+ORDER BY o.id
+`
+
+type ListObjectsRow struct {
+	ID        int64
+	ObjectKey string
+	Sha256    sql.NullString
+	Size      sql.NullInt64
+	Status    string
+	FileCount int64
+}
+
+func (q *Queries) ListObjects(ctx context.Context) ([]ListObjectsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listObjects)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListObjectsRow
+	for rows.Next() {
+		var i ListObjectsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.ObjectKey,
+			&i.Sha256,
+			&i.Size,
+			&i.Status,
+			&i.FileCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOwnerFiles = `-- name: ListOwnerFiles :many
+SELECT f.name, COALESCE(c.name, '') AS category, f.size
+FROM files f
+LEFT JOIN categories c ON c.id = f.category_id
+WHERE f.owner_id = $1 AND f.object_id IS NOT NULL
+ORDER BY c.name, f.name
+`
+
+type ListOwnerFilesRow struct {
+	Name     string
+	Category string
+	Size     sql.NullInt64
+}
+
+func (q *Queries) ListOwnerFiles(ctx context.Context, ownerID string) ([]ListOwnerFilesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listOwnerFiles, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListOwnerFilesRow
+	for rows.Next() {
+		var i ListOwnerFilesRow
+		if err := rows.Scan(&i.Name, &i.Category, &i.Size); err != nil {
 			return nil, err
 		}
-		items = append(items, file_name)
+		items = append(items, i)
 	}
 	if err := rows.Close(); err != nil {
 		return nil, err
@@ -109,30 +851,454 @@ func (q *Queries) ListFilesInCategory(ctx context.Context, theme sql.NullString)
 	return items, nil
 }
 
-const renameFile = `-- name: RenameFile :exec
-UPDATE line_01 SET file_name = $1 WHERE file_name = $2
+const lockContentHash = `-- name: LockContentHash :exec
+SELECT pg_advisory_xact_lock(hashtext($1::text))
+`
+
+func (q *Queries) LockContentHash(ctx context.Context, key string) error {
+	_, err := q.db.ExecContext(ctx, lockContentHash, key)
+	return err
+}
+
+const pruneRateLimitBuckets = `-- name: PruneRateLimitBuckets :execrows
+DELETE FROM rate_limit_buckets WHERE updated_at < $1
+`
+
+func (q *Queries) PruneRateLimitBuckets(ctx context.Context, updatedAt time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, pruneRateLimitBuckets, updatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const pruneWebhookEvents = `-- name: PruneWebhookEvents :execrows
+DELETE FROM webhook_events WHERE claimed_at < $1
+`
+
+func (q *Queries) PruneWebhookEvents(ctx context.Context, claimedAt time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, pruneWebhookEvents, claimedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const recordShareAccess = `-- name: RecordShareAccess :one
+UPDATE shares s
+SET access_count = s.access_count + 1, last_accessed_at = now()
+FROM files f
+JOIN objects o ON o.id = f.object_id
+WHERE s.id = $1 AND s.file_id = f.id AND s.revoked_at IS NULL AND s.expires_at > now()
+  AND o.status <> 'broken'
+RETURNING o.object_key, f.name, f.extension, f.mime_type
+`
+
+type RecordShareAccessRow struct {
+	ObjectKey string
+	Name      string
+	Extension string
+	MimeType  sql.NullString
+}
+
+func (q *Queries) RecordShareAccess(ctx context.Context, id string) (RecordShareAccessRow, error) {
+	row := q.db.QueryRowContext(ctx, recordShareAccess, id)
+	var i RecordShareAccessRow
+	err := row.Scan(
+		&i.ObjectKey,
+		&i.Name,
+		&i.Extension,
+		&i.MimeType,
+	)
+	return i, err
+}
+
+const releaseUsage = `-- name: ReleaseUsage :exec
+UPDATE storage_usage
+SET bytes = GREATEST(bytes - $1::bigint, 0), files = GREATEST(files - 1, 0), updated_at = now()
+WHERE owner_id = $2
+`
+
+type ReleaseUsageParams struct {
+	Bytes   int64
+	OwnerID string
+}
+
+func (q *Queries) ReleaseUsage(ctx context.Context, arg ReleaseUsageParams) error {
+	_, err := q.db.ExecContext(ctx, releaseUsage, arg.Bytes, arg.OwnerID)
+	return err
+}
+
+const removeFileTag = `-- name: RemoveFileTag :execrows
+DELETE FROM file_tags ft
+USING tags t
+WHERE ft.tag_id = t.id AND ft.file_id = $1 AND t.name = $2
+`
+
+type RemoveFileTagParams struct {
+	FileID int64
+	Tag    string
+}
+
+func (q *Queries) RemoveFileTag(ctx context.Context, arg RemoveFileTagParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, removeFileTag, arg.FileID, arg.Tag)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const renameFile = `-- name: RenameFile :execrows
+UPDATE files SET name = $1, updated_at = now()
+WHERE owner_id = $2 AND folder = '/' AND name = $3
 `
 
 type RenameFileParams struct {
-	FileName   string
-	FileName_2 string
+	NewName string
+	OwnerID string
+	OldName string
+}
+
+func (q *Queries) RenameFile(ctx context.Context, arg RenameFileParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, renameFile, arg.NewName, arg.OwnerID, arg.OldName)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const reserveUsage = `-- name: ReserveUsage :execrows
+UPDATE storage_usage
+SET bytes = bytes + $1::bigint, files = files + 1, updated_at = now()
+WHERE owner_id = $2
+  AND ($3::bigint = 0 OR bytes + $1::bigint <= $3::bigint)
+  AND ($4::bigint = 0 OR files + 1 <= $4::bigint)
+`
+
+type ReserveUsageParams struct {
+	Bytes    int64
+	OwnerID  string
+	MaxBytes int64
+	MaxFiles int64
+}
+
+func (q *Queries) ReserveUsage(ctx context.Context, arg ReserveUsageParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, reserveUsage,
+		arg.Bytes,
+		arg.OwnerID,
+		arg.MaxBytes,
+		arg.MaxFiles,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeShares = `-- name: RevokeShares :many
+UPDATE shares
+SET revoked_at = now()
+WHERE revoked_at IS NULL AND expires_at > now()
+  AND file_id = (SELECT f.id FROM files f WHERE f.owner_id = $1 AND f.folder = '/' AND f.name = $2)
+RETURNING access_count
+`
+
+type RevokeSharesParams struct {
+	OwnerID string
+	Name    string
+}
+
+func (q *Queries) RevokeShares(ctx context.Context, arg RevokeSharesParams) ([]int64, error) {
+	rows, err := q.db.QueryContext(ctx, revokeShares, arg.OwnerID, arg.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var access_count int64
+		if err := rows.Scan(&access_count); err != nil {
+			return nil, err
+		}
+		items = append(items, access_count)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const savePendingEvent = `-- name: SavePendingEvent :exec
+INSERT INTO pending_events (event_id, payload, redelivery) VALUES ($1, $2, $3)
+`
+
+type SavePendingEventParams struct {
+	EventID    string
+	Payload    json.RawMessage
+	Redelivery bool
+}
+
+func (q *Queries) SavePendingEvent(ctx context.Context, arg SavePendingEventParams) error {
+	_, err := q.db.ExecContext(ctx, savePendingEvent, arg.EventID, arg.Payload, arg.Redelivery)
+	return err
+}
+
+const saveUploadSession = `-- name: SaveUploadSession :exec
+INSERT INTO upload_sessions (session_key, filename) VALUES ($1, $2)
+ON CONFLICT (session_key) DO UPDATE SET filename = EXCLUDED.filename, saved_at = now()
+`
+
+type SaveUploadSessionParams struct {
+	SessionKey string
+	Filename   string
+}
+
+func (q *Queries) SaveUploadSession(ctx context.Context, arg SaveUploadSessionParams) error {
+	_, err := q.db.ExecContext(ctx, saveUploadSession, arg.SessionKey, arg.Filename)
+	return err
+}
+
+const setQuota = `-- name: SetQuota :exec
+INSERT INTO quotas (owner_id, max_bytes, max_files, updated_by, updated_at)
+VALUES ($1, $2, $3, $4, now())
+ON CONFLICT (owner_id) DO UPDATE
+SET max_bytes = EXCLUDED.max_bytes, max_files = EXCLUDED.max_files,
+    updated_by = EXCLUDED.updated_by, updated_at = now()
+`
+
+type SetQuotaParams struct {
+	OwnerID   string
+	MaxBytes  sql.NullInt64
+	MaxFiles  sql.NullInt64
+	UpdatedBy sql.NullString
+}
+
+func (q *Queries) SetQuota(ctx context.Context, arg SetQuotaParams) error {
+	_, err := q.db.ExecContext(ctx, setQuota,
+		arg.OwnerID,
+		arg.MaxBytes,
+		arg.MaxFiles,
+		arg.UpdatedBy,
+	)
+	return err
+}
+
+const setUserLanguage = `-- name: SetUserLanguage :exec
+INSERT INTO user_languages (user_id, language, updated_at)
+VALUES ($1, $2, now())
+ON CONFLICT (user_id) DO UPDATE
+SET language = EXCLUDED.language, updated_at = now()
+`
+
+type SetUserLanguageParams struct {
+	UserID   string
+	Language string
 }
 
-func (q *Queries) RenameFile(ctx context.Context, arg RenameFileParams) error {
-	_, err := q.db.ExecContext(ctx, renameFile, arg.FileName, arg.FileName_2)
+func (q *Queries) SetUserLanguage(ctx context.Context, arg SetUserLanguageParams) error {
+	_, err := q.db.ExecContext(ctx, setUserLanguage, arg.UserID, arg.Language)
 	return err
 }
 
-const updateFileURL = `-- name: UpdateFileURL :exec
-UPDATE line_01 SET file_content = $1 WHERE file_name = $2
+const suspendUser = `-- name: SuspendUser :exec
+INSERT INTO suspended_users (user_id, reason, suspended_by)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id) DO UPDATE
+SET reason = EXCLUDED.reason, suspended_by = EXCLUDED.suspended_by, suspended_at = now()
 `
 
-type UpdateFileURLParams struct {
-	FileContent sql.NullString
-	FileName    string
+type SuspendUserParams struct {
+	UserID      string
+	Reason      sql.NullString
+	SuspendedBy string
 }
 
-func (q *Queries) UpdateFileURL(ctx context.Context, arg UpdateFileURLParams) error {
-	_, err := q.db.ExecContext(ctx, updateFileURL, arg.FileContent, arg.FileName)
+func (q *Queries) SuspendUser(ctx context.Context, arg SuspendUserParams) error {
+	_, err := q.db.ExecContext(ctx, suspendUser, arg.UserID, arg.Reason, arg.SuspendedBy)
 	return err
 }
+
+const takePendingEvents = `-- name: TakePendingEvents :many
+WITH taken AS (
+    DELETE FROM pending_events RETURNING id, event_id, payload, redelivery
+)
+SELECT event_id, payload, redelivery FROM taken ORDER BY id
+`
+
+type TakePendingEventsRow struct {
+	EventID    string
+	Payload    json.RawMessage
+	Redelivery bool
+}
+
+func (q *Queries) TakePendingEvents(ctx context.Context) ([]TakePendingEventsRow, error) {
+	rows, err := q.db.QueryContext(ctx, takePendingEvents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TakePendingEventsRow
+	for rows.Next() {
+		var i TakePendingEventsRow
+		if err := rows.Scan(&i.EventID, &i.Payload, &i.Redelivery); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const takeRateLimitToken = `-- name: TakeRateLimitToken :one
+INSERT INTO rate_limit_buckets AS b (bucket_key, tokens, updated_at)
+VALUES ($1, $2::float8 - 1, now())
+ON CONFLICT (bucket_key) DO UPDATE
+SET tokens = LEAST($2::float8,
+        b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $3::float8) - 1,
+    updated_at = now()
+WHERE LEAST($2::float8,
+        b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $3::float8) >= 1
+RETURNING tokens
+`
+
+type TakeRateLimitTokenParams struct {
+	BucketKey       string
+	Capacity        float64
+	RefillPerSecond float64
+}
+
+func (q *Queries) TakeRateLimitToken(ctx context.Context, arg TakeRateLimitTokenParams) (float64, error) {
+	row := q.db.QueryRowContext(ctx, takeRateLimitToken, arg.BucketKey, arg.Capacity, arg.RefillPerSecond)
+	var tokens float64
+	err := row.Scan(&tokens)
+	return tokens, err
+}
+
+const takeUploadSessions = `-- name: TakeUploadSessions :many
+DELETE FROM upload_sessions RETURNING session_key, filename
+`
+
+type TakeUploadSessionsRow struct {
+	SessionKey string
+	Filename   string
+}
+
+func (q *Queries) TakeUploadSessions(ctx context.Context) ([]TakeUploadSessionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, takeUploadSessions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TakeUploadSessionsRow
+	for rows.Next() {
+		var i TakeUploadSessionsRow
+		if err := rows.Scan(&i.SessionKey, &i.Filename); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const unsuspendUser = `-- name: UnsuspendUser :execrows
+DELETE FROM suspended_users WHERE user_id = $1
+`
+
+func (q *Queries) UnsuspendUser(ctx context.Context, userID string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, unsuspendUser, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateObjectStatus = `-- name: UpdateObjectStatus :exec
+UPDATE objects SET status = $1 WHERE id = $2
+`
+
+type UpdateObjectStatusParams struct {
+	Status string
+	ID     int64
+}
+
+func (q *Queries) UpdateObjectStatus(ctx context.Context, arg UpdateObjectStatusParams) error {
+	_, err := q.db.ExecContext(ctx, updateObjectStatus, arg.Status, arg.ID)
+	return err
+}
+
+const upsertAutosave = `-- name: UpsertAutosave :exec
+INSERT INTO autosave_settings (owner_id, enabled, category, updated_by, updated_at)
+VALUES ($1, $2, $3, $4, now())
+ON CONFLICT (owner_id) DO UPDATE
+SET enabled = EXCLUDED.enabled, category = EXCLUDED.category,
+    updated_by = EXCLUDED.updated_by, updated_at = now()
+`
+
+type UpsertAutosaveParams struct {
+	OwnerID   string
+	Enabled   bool
+	Category  string
+	UpdatedBy sql.NullString
+}
+
+func (q *Queries) UpsertAutosave(ctx context.Context, arg UpsertAutosaveParams) error {
+	_, err := q.db.ExecContext(ctx, upsertAutosave,
+		arg.OwnerID,
+		arg.Enabled,
+		arg.Category,
+		arg.UpdatedBy,
+	)
+	return err
+}
+
+const upsertCategory = `-- name: UpsertCategory :one
+INSERT INTO categories (owner_id, name)
+VALUES ($1, $2)
+ON CONFLICT (owner_id, name) DO UPDATE SET name = EXCLUDED.name
+RETURNING id
+`
+
+type UpsertCategoryParams struct {
+	OwnerID string
+	Name    string
+}
+
+func (q *Queries) UpsertCategory(ctx context.Context, arg UpsertCategoryParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, upsertCategory, arg.OwnerID, arg.Name)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const upsertTag = `-- name: UpsertTag :one
+INSERT INTO tags (owner_id, name)
+VALUES ($1, $2)
+ON CONFLICT (owner_id, name) DO UPDATE SET name = EXCLUDED.name
+RETURNING id
+`
+
+type UpsertTagParams struct {
+	OwnerID string
+	Name    string
+}
+
+func (q *Queries) UpsertTag(ctx context.Context, arg UpsertTagParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, upsertTag, arg.OwnerID, arg.Name)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
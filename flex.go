@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// flexListLimit caps the rows in one bubble to stay under LINE's size limit
+const flexListLimit = 50
+
+// fileListing is one row of a Flex file list
+type fileListing struct {
+	Name string
+	Tags []string
+}
+
+// fileListFlex renders files as a Flex bubble with each file's tags under its name
+func fileListFlex(ctx context.Context, title string, files []fileListing) *linebot.FlexMessage {
+	rows := []linebot.FlexComponent{
+		&linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   title,
+			Weight: linebot.FlexTextWeightTypeBold,
+			Size:   linebot.FlexTextSizeTypeLg,
+			Wrap:   true,
+		},
+		&linebot.SeparatorComponent{
+			Type:   linebot.FlexComponentTypeSeparator,
+			Margin: linebot.FlexComponentMarginTypeMd,
+		},
+	}
+
+	for i, file := range files {
+		if i == flexListLimit {
+			rows = append(rows, &linebot.TextComponent{
+				Type:   linebot.FlexComponentTypeText,
+				Text:   t(ctx, msgMoreFiles, len(files)-flexListLimit),
+				Size:   linebot.FlexTextSizeTypeXs,
+				Color:  "#888888",
+				Margin: linebot.FlexComponentMarginTypeMd,
+			})
+			break
+		}
+
+		entry := []linebot.FlexComponent{
+			&linebot.TextComponent{
+				Type: linebot.FlexComponentTypeText,
+				Text: file.Name,
+				Size: linebot.FlexTextSizeTypeSm,
+				Wrap: true,
+			},
+		}
+		if len(file.Tags) > 0 {
+			entry = append(entry, &linebot.TextComponent{
+				Type:  linebot.FlexComponentTypeText,
+				Text:  "#" + strings.Join(file.Tags, " #"),
+				Size:  linebot.FlexTextSizeTypeXs,
+				Color: "#1DB446",
+				Wrap:  true,
+			})
+		}
+		rows = append(rows, &linebot.BoxComponent{
+			Type:     linebot.FlexComponentTypeBox,
+			Layout:   linebot.FlexBoxLayoutTypeVertical,
+			Margin:   linebot.FlexComponentMarginTypeMd,
+			Contents: entry,
+		})
+	}
+
+	bubble := &linebot.BubbleContainer{
+		Type: linebot.FlexContainerTypeBubble,
+		Body: &linebot.BoxComponent{
+			Type:     linebot.FlexComponentTypeBox,
+			Layout:   linebot.FlexBoxLayoutTypeVertical,
+			Contents: rows,
+		},
+	}
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	altText := title + ": " + strings.Join(names, ", ")
+	if len([]rune(altText)) > 400 { // altText สูงสุด 400 ตัวอักษร
+		altText = string([]rune(altText)[:397]) + "..."
+	}
+	return linebot.NewFlexMessage(altText, bubble)
+}
+
+// usageBar is one labelled progress bar of a quota bubble
+type usageBar struct {
+	Label string
+	Used  string
+	Limit string
+	Ratio float64 // 0..1, negative when unlimited
+}
+
+// quotaFlex renders storage usage as a Flex bubble with one progress bar per limit
+func quotaFlex(ctx context.Context, title string, bars []usageBar) *linebot.FlexMessage {
+	rows := []linebot.FlexComponent{
+		&linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   title,
+			Weight: linebot.FlexTextWeightTypeBold,
+			Size:   linebot.FlexTextSizeTypeLg,
+			Wrap:   true,
+		},
+	}
+
+	var alt []string
+	for _, bar := range bars {
+		summary := t(ctx, msgUsedOf, bar.Used, bar.Limit)
+		color := "#1DB446"
+		width := 0.0
+		if bar.Ratio >= 0 {
+			width = min(bar.Ratio, 1)
+			summary += fmt.Sprintf(" (%.0f%%)", bar.Ratio*100)
+			switch {
+			case bar.Ratio >= 0.9:
+				color = "#E53935" // เกือบเต็ม
+			case bar.Ratio >= 0.75:
+				color = "#FB8C00"
+			}
+		}
+		alt = append(alt, bar.Label+": "+summary)
+
+		fill := []linebot.FlexComponent{}
+		if width > 0 {
+			fill = append(fill, &linebot.BoxComponent{
+				Type:            linebot.FlexComponentTypeBox,
+				Layout:          linebot.FlexBoxLayoutTypeVertical,
+				Width:           fmt.Sprintf("%.0f%%", width*100),
+				Height:          "8px",
+				BackgroundColor: color,
+				Contents:        []linebot.FlexComponent{},
+			})
+		}
+
+		rows = append(rows, &linebot.BoxComponent{
+			Type:   linebot.FlexComponentTypeBox,
+			Layout: linebot.FlexBoxLayoutTypeVertical,
+			Margin: linebot.FlexComponentMarginTypeLg,
+			Contents: []linebot.FlexComponent{
+				&linebot.TextComponent{
+					Type:   linebot.FlexComponentTypeText,
+					Text:   bar.Label,
+					Size:   linebot.FlexTextSizeTypeSm,
+					Weight: linebot.FlexTextWeightTypeBold,
+				},
+				&linebot.BoxComponent{
+					Type:            linebot.FlexComponentTypeBox,
+					Layout:          linebot.FlexBoxLayoutTypeVertical,
+					Margin:          linebot.FlexComponentMarginTypeSm,
+					Height:          "8px",
+					BackgroundColor: "#EEEEEE",
+					Contents:        fill,
+				},
+				&linebot.TextComponent{
+					Type:   linebot.FlexComponentTypeText,
+					Text:   summary,
+					Size:   linebot.FlexTextSizeTypeXs,
+					Color:  "#888888",
+					Margin: linebot.FlexComponentMarginTypeSm,
+				},
+			},
+		})
+	}
+
+	bubble := &linebot.BubbleContainer{
+		Type: linebot.FlexContainerTypeBubble,
+		Body: &linebot.BoxComponent{
+			Type:     linebot.FlexComponentTypeBox,
+			Layout:   linebot.FlexBoxLayoutTypeVertical,
+			Contents: rows,
+		},
+	}
+	return linebot.NewFlexMessage(title+": "+strings.Join(alt, ", "), bubble)
+}
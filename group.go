@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// การตอบในกลุ่ม: ตอบเฉพาะข้อความที่ขึ้นต้นด้วย prefix หรือ mention บอท
+var (
+	commandPrefix  = "/"   // GROUP_COMMAND_PREFIX, empty disables prefixed commands
+	requireMention = false // GROUP_REQUIRE_MENTION
+	botUserID      string  // the bot's own user ID, used to detect mentions
+)
+
+// initGroupEtiquette loads the group command settings and the bot's user ID
+func initGroupEtiquette() {
+	if prefix, ok := os.LookupEnv("GROUP_COMMAND_PREFIX"); ok {
+		commandPrefix = strings.TrimSpace(prefix)
+	}
+	requireMention = os.Getenv("GROUP_REQUIRE_MENTION") == "true"
+
+	info, err := bot.GetBotInfo().Do()
+	if err != nil {
+		slog.Warn("could not get bot info, mentions will not be detected", "error", err)
+		return
+	}
+	botUserID = info.UserID
+
+	if requireMention {
+		slog.Info("group commands: mention required")
+	} else {
+		slog.Info("group commands: prefix or mention", "prefix", commandPrefix)
+	}
+}
+
+// isGroupSource reports whether an event comes from a group or room chat
+func isGroupSource(source *linebot.EventSource) bool {
+	return source.Type == linebot.EventSourceTypeGroup || source.Type == linebot.EventSourceTypeRoom
+}
+
+// addressedText returns the command text of a message with the command prefix
+// or bot mention removed. In groups and rooms it returns false for messages
+// not addressed to the bot, which must be ignored silently.
+func addressedText(source *linebot.EventSource, message *linebot.TextMessage) (string, bool) {
+	if text, ok := stripBotMention(message); ok {
+		if commandPrefix != "" {
+			text = strings.TrimSpace(strings.TrimPrefix(text, commandPrefix))
+		}
+		return text, true
+	}
+
+	text := strings.TrimSpace(message.Text)
+	if commandPrefix != "" && strings.HasPrefix(text, commandPrefix) {
+		if requireMention && isGroupSource(source) {
+			return "", false
+		}
+		return strings.TrimSpace(strings.TrimPrefix(text, commandPrefix)), true
+	}
+
+	if isGroupSource(source) {
+		return "", false
+	}
+	return message.Text, true
+}
+
+// stripBotMention removes the mention of the bot from a message, if present.
+// Mention positions are counted in UTF-16 code units.
+func stripBotMention(message *linebot.TextMessage) (string, bool) {
+	if botUserID == "" || message.Mention == nil {
+		return "", false
+	}
+
+	units := utf16.Encode([]rune(message.Text))
+	for _, mentionee := range message.Mention.Mentionees {
+		if mentionee.UserID != botUserID {
+			continue
+		}
+		end := mentionee.Index + mentionee.Length
+		if mentionee.Index < 0 || end > len(units) {
+			continue
+		}
+		text := string(utf16.Decode(units[:mentionee.Index])) + string(utf16.Decode(units[end:]))
+		return strings.TrimSpace(text), true
+	}
+	return "", false
+}
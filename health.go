@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// เวลารอสูงสุดของการตรวจ dependency
+const (
+	startupCheckTimeout = 10 * time.Second // once at startup, before serving
+	readyCheckTimeout   = 2 * time.Second  // per dependency on every /readyz probe
+)
+
+// checkDatabase pings Postgres; sql.Open alone never connects
+func checkDatabase(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := dbconn.PingContext(ctx); err != nil {
+		return fmt.Errorf("postgres unreachable: %w", err)
+	}
+	return nil
+}
+
+// checkStorage verifies that the bucket exists and the credentials can reach it
+func checkStorage(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if _, err := s3Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		return fmt.Errorf("bucket %s unreachable: %w", bucket, err)
+	}
+	return nil
+}
+
+// healthzHandler is the liveness probe: the process is up and serving HTTP
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// readyzHandler is the readiness probe: it reports 503 unless Postgres and
+// the bucket both answer within readyCheckTimeout
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"postgres": "ok", "storage": "ok"}
+	ready := true
+	if err := checkDatabase(r.Context(), readyCheckTimeout); err != nil {
+		checks["postgres"], ready = err.Error(), false
+	}
+	if err := checkStorage(r.Context(), readyCheckTimeout); err != nil {
+		checks["storage"], ready = err.Error(), false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		slog.WarnContext(r.Context(), "readiness check failed", "checks", checks)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"ready": ready, "checks": checks})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"Line01/db"
+)
+
+// LINE redelivers webhooks (isRedelivery) when an earlier delivery was not
+// acknowledged, and may deliver an event twice. Each webhookEventId is
+// claimed in webhook_events before the event is handled, so duplicates are
+// skipped.
+const (
+	// eventClaimTimeout is how long a claimed event that never completed
+	// blocks its redeliveries; after that the handler is assumed to have
+	// crashed and the event may be handled again
+	eventClaimTimeout = 10 * time.Minute
+
+	// eventRetention is how long handled event IDs are kept; LINE stops
+	// redelivering long before that
+	eventRetention = 7 * 24 * time.Hour
+)
+
+// claimEvent reports whether the event of ctx should be handled. It returns
+// false for events that have already been handled or are being handled.
+// Events without a webhookEventId cannot be deduplicated and are always
+// handled, as are all events when the claim cannot be recorded.
+func claimEvent(ctx context.Context, redelivery bool) bool {
+	id := eventID(ctx)
+	if id == "" || strings.HasPrefix(id, localEventPrefix) {
+		return true
+	}
+	n, err := queries.ClaimWebhookEvent(ctx, db.ClaimWebhookEventParams{
+		EventID:     id,
+		StaleBefore: time.Now().Add(-eventClaimTimeout),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "error claiming event, handling it anyway", "error", err)
+		return true
+	}
+	if n == 0 {
+		duplicateEventsTotal.WithLabelValues(strconv.FormatBool(redelivery)).Inc()
+		slog.InfoContext(ctx, "skipping duplicate event", "redelivery", redelivery)
+		return false
+	}
+	return true
+}
+
+// completeEvent marks the event of ctx as handled, even if it ran out of time
+func completeEvent(ctx context.Context) {
+	id := eventID(ctx)
+	if id == "" || strings.HasPrefix(id, localEventPrefix) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), replyTimeout)
+	defer cancel()
+	if err := queries.CompleteWebhookEvent(ctx, id); err != nil {
+		slog.ErrorContext(ctx, "error marking event as handled", "error", err)
+	}
+}
+
+// pruneEvents forgets handled events older than eventRetention
+func pruneEvents(ctx context.Context) {
+	n, err := queries.PruneWebhookEvents(ctx, time.Now().Add(-eventRetention))
+	if err != nil {
+		slog.Error("error pruning handled events", "error", err)
+		return
+	}
+	slog.Debug("pruned handled events", "count", n)
+}
+
+// eventKey returns the ID that keys the side effects of the event of ctx,
+// so a retried event finds the rows it already wrote
+func eventKey(ctx context.Context) sql.NullString {
+	id := eventID(ctx)
+	return sql.NullString{String: id, Valid: id != ""}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/image/draw"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+)
+
+// ขีดจำกัดของ LINE image message
+const (
+	lineImageMaxBytes     = 10 * 1024 * 1024 // originalContentUrl
+	lineImageMaxDimension = 4096
+	linePreviewMaxBytes   = 1024 * 1024 // previewImageUrl
+	linePreviewDimension  = 1024
+
+	// imageMaxDecodePixels bounds the images decoded for a rendition, about
+	// 200MB once decoded; the size a file declares is checked before decoding
+	imageMaxDecodePixels = 50_000_000
+
+	renditionPrefix = "renditions/"
+)
+
+var errImageTooLarge = errors.New("image dimensions are too large to decode")
+
+// jpegQualities are tried in order until the encoded image fits the byte limit.
+var jpegQualities = []int{90, 80, 70, 60, 50}
+
+// isImageFile reports whether the stored file can be sent as an image message
+// (directly or through a rendition).
+func isImageFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jpeg", ".jpg", ".png", ".gif", ".webp", ".bmp":
+		return true
+	}
+	return false
+}
+
+// imageMessageURLs returns the original and preview URLs to use in a LINE image
+// message for the stored object behind fileURL. Objects that already satisfy
+// LINE's limits are served as-is; otherwise JPEG renditions are generated once
+// and cached under renditionPrefix. The original object is never modified.
+func imageMessageURLs(ctx context.Context, fileURL string) (string, string, error) {
+	key := filepath.Base(fileURL)
+
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("error reading object metadata: %w", err)
+	}
+	size := aws.ToInt64(head.ContentLength)
+	contentType := aws.ToString(head.ContentType)
+
+	var data []byte
+	if (contentType == "image/jpeg" || contentType == "image/png") && size <= linePreviewMaxBytes {
+		// เล็กพอสำหรับทั้ง original และ preview ถ้าขนาดภาพไม่เกิน
+		data, err = downloadFromR2(ctx, key)
+		if err != nil {
+			return "", "", err
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return "", "", fmt.Errorf("error decoding image: %w", err)
+		}
+		if config.Width <= lineImageMaxDimension && config.Height <= lineImageMaxDimension {
+			return fileURL, fileURL, nil
+		}
+	}
+
+	base := strings.TrimSuffix(key, path.Ext(key))
+	originalKey := renditionPrefix + base + ".jpg"
+	previewKey := renditionPrefix + base + ".preview.jpg"
+
+	originalOK, err := objectExists(ctx, originalKey)
+	if err != nil {
+		return "", "", err
+	}
+	previewOK, err := objectExists(ctx, previewKey)
+	if err != nil {
+		return "", "", err
+	}
+	if originalOK && previewOK {
+		return r2PublicURL(originalKey), r2PublicURL(previewKey), nil
+	}
+
+	if data == nil {
+		data, err = downloadFromR2(ctx, key)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	img, format, err := decodeImage(data)
+	if err != nil {
+		return "", "", err
+	}
+
+	originalURL := fileURL
+	bounds := img.Bounds()
+	fits := (format == "jpeg" || format == "png") &&
+		len(data) <= lineImageMaxBytes &&
+		bounds.Dx() <= lineImageMaxDimension && bounds.Dy() <= lineImageMaxDimension
+	if !fits {
+		if !originalOK {
+			rendition, err := encodeRendition(img, lineImageMaxDimension, lineImageMaxBytes)
+			if err != nil {
+				return "", "", err
+			}
+			if _, err := uploadToR2(ctx, originalKey, rendition); err != nil {
+				return "", "", err
+			}
+		}
+		originalURL = r2PublicURL(originalKey)
+	}
+
+	if !previewOK {
+		preview, err := encodeRendition(img, linePreviewDimension, linePreviewMaxBytes)
+		if err != nil {
+			return "", "", err
+		}
+		if _, err := uploadToR2(ctx, previewKey, preview); err != nil {
+			return "", "", err
+		}
+	}
+
+	return originalURL, r2PublicURL(previewKey), nil
+}
+
+// decodeImage decodes data, refusing images whose declared dimensions would
+// take more than imageMaxDecodePixels to hold in memory
+func decodeImage(data []byte) (image.Image, string, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("error decoding image: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > imageMaxDecodePixels {
+		return nil, "", fmt.Errorf("%w: %dx%d", errImageTooLarge, config.Width, config.Height)
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("error decoding image: %w", err)
+	}
+	return img, format, nil
+}
+
+// encodeRendition scales img to fit within maxDim and encodes it as JPEG no
+// larger than maxBytes, lowering quality and then resolution as needed.
+func encodeRendition(img image.Image, maxDim int, maxBytes int) ([]byte, error) {
+	img = fitWithin(img, maxDim)
+	for {
+		for _, quality := range jpegQualities {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+				return nil, fmt.Errorf("error encoding rendition: %w", err)
+			}
+			if buf.Len() <= maxBytes {
+				return buf.Bytes(), nil
+			}
+		}
+
+		bounds := img.Bounds()
+		if bounds.Dx() <= 64 || bounds.Dy() <= 64 {
+			return nil, errors.New("image cannot be reduced below size limit")
+		}
+		img = fitWithin(img, max(bounds.Dx(), bounds.Dy())*3/4)
+	}
+}
+
+// fitWithin returns img scaled down so neither side exceeds maxDim, flattened
+// onto a white background since JPEG has no alpha channel.
+func fitWithin(img image.Image, maxDim int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > maxDim || height > maxDim {
+		if width >= height {
+			height = max(1, height*maxDim/width)
+			width = maxDim
+		} else {
+			width = max(1, width*maxDim/height)
+			height = maxDim
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+func objectExists(ctx context.Context, key string) (bool, error) {
+	_, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error checking object %s: %w", key, err)
+	}
+	return true, nil
+}
+
+func downloadFromR2(ctx context.Context, key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, storageTimeout)
+	defer cancel()
+
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file from R2: %w", err)
+	}
+	defer result.Body.Close()
+
+	return io.ReadAll(result.Body)
+}
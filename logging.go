@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+type contextKey int
+
+const (
+	eventIDKey contextKey = iota
+	languageKey
+)
+
+// localEventPrefix marks correlation IDs generated here rather than by LINE
+const localEventPrefix = "local-"
+
+// initLogging sets up JSON logging at the level given by LOG_LEVEL
+// (debug, info, warn or error; default info). The standard log package is
+// routed through the same handler.
+func initLogging() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(os.Getenv("LOG_LEVEL")))); err != nil {
+		level = slog.LevelInfo
+	}
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(contextHandler{handler}))
+}
+
+// contextHandler adds the correlation ID of the event being handled to every
+// record logged with a context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := eventID(ctx); id != "" {
+		r.AddAttrs(slog.String("event_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// withEventID returns a context carrying the correlation ID of a webhook event
+func withEventID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, eventIDKey, id)
+}
+
+// eventID returns the correlation ID carried by ctx, if any
+func eventID(ctx context.Context) string {
+	id, _ := ctx.Value(eventIDKey).(string)
+	return id
+}
+
+// newEventID generates a correlation ID for events LINE sent without a
+// webhookEventId
+func newEventID() string {
+	raw := make([]byte, 8)
+	rand.Read(raw)
+	return localEventPrefix + hex.EncodeToString(raw)
+}
+
+// errorReply builds the message telling a user that err stopped their
+// command: text, a hint when trying again may help, and the correlation ID so
+// support can find the matching logs
+func errorReply(ctx context.Context, text string, err error) string {
+	category := errorCategoryInternal
+	if err != nil {
+		category = errorCategory(err)
+	}
+	errorRepliesTotal.WithLabelValues(category).Inc()
+
+	if hint, ok := errorHint[category]; ok {
+		text += "\n" + t(ctx, hint)
+	}
+	if id := eventID(ctx); id != "" {
+		text += "\n" + t(ctx, msgRef, id)
+	}
+	return text
+}
+
+// webhookMeta holds the per-event fields of a webhook body that the SDK does
+// not expose
+type webhookMeta struct {
+	WebhookEventID  string `json:"webhookEventId"`
+	DeliveryContext struct {
+		IsRedelivery bool `json:"isRedelivery"`
+	} `json:"deliveryContext"`
+}
+
+// parseWebhookMeta returns the metadata of each event in a webhook body, in
+// the same order as the events returned by ParseRequest
+func parseWebhookMeta(body []byte) []webhookMeta {
+	var payload struct {
+		Events []webhookMeta `json:"events"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+	return payload.Events
+}
+
+// fatal logs a startup error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
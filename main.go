@@ -3,17 +3,19 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
-	"time"
 
 	"Line01/db"
 
@@ -21,278 +23,658 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/line/line-bot-sdk-go/linebot"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
 	bot      *linebot.Client
 	dbconn   *sql.DB
-	userFile = make(map[string]string) // Temporary session for filename per user
+	userFile = make(map[string]string) // Temporary session for filename per user and chat (see sessionKey)
 	mu       sync.Mutex                // Ensures safe concurrent access
 	s3Client *s3.Client
 	bucket   string
 	queries  *db.Queries // Add queries variable
 )
 
+var (
+	errFileNotFound = errors.New("file not found")
+	errFileExists   = errors.New("file already exists")
+	errFileBroken   = errors.New("file content is missing from storage")
+
+	errUnsupportedMessage = errors.New("unsupported message type")
+)
+
 func main() {
 	var err error
 	err = godotenv.Load()
 	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+		fatal("error loading .env file", err)
 	}
+	initLogging()
+	initTimeouts()
+	initLanguages()
 
 	// Initialize LINE Bot Client
 	channelSecret := os.Getenv("LINE_CHANNEL_SECRET")
 	channelToken := os.Getenv("LINE_CHANNEL_TOKEN")
-	bot, err = linebot.New(channelSecret, channelToken)
+	bot, err = linebot.New(channelSecret, channelToken, linebot.WithHTTPClient(lineHTTPClient()))
 	if err != nil {
-		log.Fatalf("Error creating LINE bot client: %v", err)
+		fatal("error creating LINE bot client", err)
 	}
 
+	initGroupEtiquette()
+	initAdmins()
+	initQuotas()
+
 	// Connect to PostgreSQL
 	dbConnStr := os.Getenv("DB_CONN_STR")
+	if !isAdminMode() {
+		// The admin modes scan whole tables and may take longer
+		dbConnStr = withStatementTimeout(dbConnStr)
+	}
 	dbconn, err = sql.Open("postgres", dbConnStr)
 	if err != nil {
-		log.Fatalf("Error connecting to PostgreSQL: %v", err)
+		fatal("error connecting to PostgreSQL", err)
+	}
+	queries = instrumentDB(dbconn) // Initialize queries here
+	initMetrics()
+	if err := checkDatabase(context.Background(), startupCheckTimeout); err != nil {
+		fatal("cannot connect to PostgreSQL, check DB_CONN_STR", err)
+	}
+
+	// Schema migrations: run explicitly with the migrate subcommand,
+	// otherwise apply pending ones at startup unless AUTO_MIGRATE=false
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			fatal("migration failed", err)
+		}
+		return
+	}
+	if os.Getenv("AUTO_MIGRATE") != "false" {
+		if err := migrateUp(context.Background()); err != nil {
+			fatal("error applying migrations", err)
+		}
 	}
-	queries = db.New(dbconn) // Initialize queries here
 
 	// Initialize R2 (AWS S3-compatible)
 	s3Client, bucket, err = initR2()
 	if err != nil {
-		log.Fatalf("Error initializing R2: %v", err)
+		fatal("error initializing R2", err)
+	}
+	if err := checkStorage(context.Background(), startupCheckTimeout); err != nil {
+		fatal("cannot access the R2 bucket, check R2_BASE_ENDPOINT, R2_BUCKET_NAME and the R2 credentials", err)
+	}
+
+	// Admin mode: export the audit log as JSON lines and exit
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		if err := runAuditExport(os.Args[2:]); err != nil {
+			fatal("audit export failed", err)
+		}
+		return
+	}
+
+	// Admin mode: reconcile storage with the database and exit
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		if err := runReconcile(os.Args[2:]); err != nil {
+			fatal("reconcile failed", err)
+		}
+		return
 	}
 
 	// Set up HTTP server
 	http.HandleFunc("/callback", callbackHandler)
+	http.HandleFunc(sharePath, shareHandler)
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
-	log.Printf("Server is running at port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	initRateLimits()
+	initEventWorkers()
+	serve(port)
 }
 
 // callbackHandler processes incoming webhook events
 func callbackHandler(w http.ResponseWriter, r *http.Request) {
+	// The body is read here as well so the webhookEventId of each event,
+	// which the SDK does not expose, can be used as its correlation ID
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
 	events, err := bot.ParseRequest(r)
 	if err != nil {
 		if err == linebot.ErrInvalidSignature {
+			slog.Warn("rejected webhook with invalid signature", "remote_addr", r.RemoteAddr)
 			w.WriteHeader(http.StatusBadRequest)
 		} else {
+			slog.Error("error parsing webhook", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 		return
 	}
+	meta := parseWebhookMeta(body)
+
+	// ⚡ Events are only queued here and acknowledged right away; the
+	// downloads, uploads and DB writes happen on the event workers
+	for i, event := range events {
+		id := ""
+		redelivery := false
+		if i < len(meta) {
+			id, redelivery = meta[i].WebhookEventID, meta[i].DeliveryContext.IsRedelivery
+		}
+		if id == "" {
+			id = newEventID()
+		}
+		ctx := withEventID(eventsCtx, id)
+		slog.DebugContext(ctx, "queueing event",
+			"type", event.Type, "source_type", event.Source.Type, "user_id", event.Source.UserID,
+			"owner_id", sourceOwnerID(event.Source), "redelivery", redelivery)
+
+		if err := eventWorkers.enqueue(ctx, event, redelivery); err != nil {
+			// LINE redelivers the whole webhook, including events already queued
+			slog.ErrorContext(ctx, "could not queue event", "error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+}
 
-	for _, event := range events {
-		if event.Type == linebot.EventTypeMessage {
-			switch message := event.Message.(type) {
-			case *linebot.TextMessage:
-				handleTextMessage(event, message)
-			case *linebot.ImageMessage, *linebot.FileMessage:
-				userID := event.Source.UserID
-				mu.Lock()
-				_, exists := userFile[userID] // Check if user started an upload
-				mu.Unlock()
+// handleEvent processes one webhook event; it runs on an event worker
+func handleEvent(ctx context.Context, event *linebot.Event) {
+	if event.Type != linebot.EventTypeMessage {
+		return
+	}
 
-				if exists {
-					handleFileMessage(event, message) // ✅ Process file if upload was started
-				} else {
-					bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Please use 'upload -category(optional) -filename' first before sending a file.")).Do()
-				}
-			default:
-				bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Use 'upload' to upload\nUse 'open' to open files")).Do()
-			}
+	// 🤫 Group chatter is dropped before anything is looked up for the sender
+	switch message := event.Message.(type) {
+	case *linebot.TextMessage:
+		if _, addressed := addressedText(event.Source, message); !addressed {
+			return
+		}
+	case *linebot.ImageMessage, *linebot.FileMessage, *linebot.VideoMessage:
+		if isGroupSource(event.Source) && !hasUploadSession(event.Source) {
+			autosaveMedia(ctx, event, message) // 📥 Archive group media if autosave is on
+			return
+		}
+	default:
+		if isGroupSource(event.Source) {
+			return
+		}
+	}
+
+	ctx = withLanguage(ctx, userLanguage(ctx, event.Source))
+
+	if isSuspended(ctx, event.Source.UserID) {
+		// 🚫 Suspended users are ignored in groups and told why in 1:1 chats
+		if !isGroupSource(event.Source) {
+			recordAudit(ctx, event.Source, "message", "", errSuspended)
+			reply(ctx, event, linebot.NewTextMessage(t(ctx, msgSuspended)))
+		}
+		return
+	}
+
+	switch message := event.Message.(type) {
+	case *linebot.TextMessage:
+		handleTextMessage(ctx, event, message)
+	case *linebot.ImageMessage, *linebot.FileMessage, *linebot.VideoMessage:
+		if hasUploadSession(event.Source) {
+			handleFileMessage(ctx, event, message) // ✅ Process file if upload was started
+		} else {
+			recordAudit(ctx, event.Source, "upload", "", errUsage)
+			reply(ctx, event, linebot.NewTextMessage(uploadFirstReply(ctx)))
 		}
+	default:
+		// Stickers, locations and the like are answered with the help text
+		recordAudit(ctx, event.Source, "message", "", errUsage)
+		reply(ctx, event, linebot.NewTextMessage(t(ctx, msgHelp)))
+	}
+}
+
+// isAdminMode reports whether the process runs one of the admin subcommands
+// (migrate, audit or reconcile) instead of the server
+func isAdminMode() bool {
+	if len(os.Args) < 2 {
+		return false
+	}
+	switch os.Args[1] {
+	case "migrate", "audit", "reconcile":
+		return true
+	}
+	return false
+}
+
+// sourceOwnerID returns the library an event belongs to: the group or room it
+// was sent in, or the user's own library in a one-to-one chat
+func sourceOwnerID(source *linebot.EventSource) string {
+	switch {
+	case source.GroupID != "":
+		return source.GroupID
+	case source.RoomID != "":
+		return source.RoomID
 	}
+	return source.UserID
+}
+
+// hasUploadSession reports whether the sender has started an upload in the
+// chat the event came from
+func hasUploadSession(source *linebot.EventSource) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	_, exists := userFile[sessionKey(source)]
+	return exists
+}
 
+// sessionKey identifies a pending upload, so that in a group only the member
+// who typed "upload" attaches the next file
+func sessionKey(source *linebot.EventSource) string {
+	return sourceOwnerID(source) + "/" + source.UserID
 }
 
 // handleTextMessage processes text commands
-func handleTextMessage(event *linebot.Event, message linebot.Message) {
+func handleTextMessage(ctx context.Context, event *linebot.Event, message linebot.Message) {
 	userID := event.Source.UserID
+	ownerID := sourceOwnerID(event.Source)
+	session := sessionKey(event.Source)
 
 	mu.Lock()
-	filename, exists := userFile[userID]
+	filename, exists := userFile[session]
 	mu.Unlock()
 
 	// ✅ First, check if it's a text message
 	if textMessage, ok := message.(*linebot.TextMessage); ok {
+		// In groups and rooms only prefixed or mentioning messages are commands
+		text, addressed := addressedText(event.Source, textMessage)
+		if !addressed {
+			return // 🤫 Ordinary group chatter, stay silent
+		}
+
 		// Process text message
-		command := strings.Fields(textMessage.Text) // ✅ Now it's safe to access .Text
-		if len(command) == 0 {
+		if strings.TrimSpace(text) == "" {
 			return
 		}
+		cmd, err := parseCommand(text)
+		name := "unknown"
+		if cmd.Root != nil {
+			name = cmd.Root.Name
+		}
 
-		switch command[0] {
-		case "upload":
-			if len(command) < 2 {
-				bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Usage: upload [category] filename")).Do()
-				return
-			}
-
-			category := "default"
-			filename := ""
-
-			if len(command) == 2 {
-				filename = command[1]
-			} else {
-				category = command[1]
-				filename = command[2]
-			}
+		// 🧾 Every handled command ends up in the audit trail
+		entry := newAudit(ctx, event.Source, name)
+		defer entry.record()
 
-			if filename == "" {
-				bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Error: filename cannot be empty")).Do()
-				return
-			}
+		// 🚦 Slow down senders and chats that send commands too fast
+		if !allowCommand(ctx, event.Source, rateClass(name)) {
+			entry.Err = errRateLimited
+			reply(ctx, event, linebot.NewTextMessage(t(ctx, msgRateLimited)))
+			return
+		}
 
-			timestamp := time.Now().Format("2006-01-02 15:04:05")
+		// 🔒 Admin commands look unknown to everyone else
+		if cmd.Root != nil && cmd.Root.Admin && !isAdmin(userID) {
+			entry.Target, entry.Err = text, errNotAdmin
+			reply(ctx, event, linebot.NewTextMessage(helpText(ctx, userID)))
+			return
+		}
+		if err != nil {
+			entry.Err = err
+			reply(ctx, event, linebot.NewTextMessage(usageReply(ctx, cmd, err)))
+			return
+		}
+		args := cmd.Args
 
-			if err := insertFileMetadata(userID, filename, category, timestamp); err != nil {
-				log.Printf("Error inserting metadata: %v", err)
-				bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Error saving file metadata.")).Do()
+		switch name {
+		case "upload":
+			category := cmd.Flag("category", "default")
+			filename := args[0]
+
+			entry.Target = filename
+			if err := insertFileMetadata(ctx, ownerID, userID, filename, category); err != nil {
+				entry.Err = err
+				if errors.Is(err, errFileExists) {
+					reply(ctx, event, linebot.NewTextMessage(t(ctx, msgFileExists, filename)))
+					return
+				}
+				slog.ErrorContext(ctx, "error inserting metadata", "file", filename, "error", err)
+				reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrSaveMetadata), err)))
 				return
 			}
 
 			mu.Lock()
-			userFile[userID] = filename
+			userFile[session] = filename
 			mu.Unlock()
 
-			bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Send file:")).Do()
+			reply(ctx, event, linebot.NewTextMessage(t(ctx, msgSendFile)))
 
 		case "open":
-			if len(command) < 2 {
-				bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Usage: open filename")).Do()
-				return
-			}
-			filesad := command[1]
+			filesad := args[0]
+			entry.Target = filesad
 
 			// 🔥 Get the actual filename from R2 (ignoring extension issues)
-			fileURL, err := getFileURL(filesad)
-			if err != nil {
-				fmt.Println("Error:", err)
-			} else {
-				filename := filepath.Base(fileURL) // Extract filename from URL
-				fmt.Println("File URL:", fileURL)
-				fmt.Println("Extracted filename:", filename)
+			fileURL, err := getFileURL(ctx, ownerID, filesad)
+			if errors.Is(err, errFileBroken) {
+				entry.Err = err
+				reply(ctx, event, linebot.NewTextMessage(t(ctx, msgFileBroken, filesad)))
+				return
 			}
 			if err != nil {
-				bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Error: File not found in R2.")).Do()
+				entry.Err = err
+				slog.InfoContext(ctx, "file not available", "file", filesad, "error", err)
+				reply(ctx, event, linebot.NewTextMessage(t(ctx, msgFileNotFound)))
 				return
 			}
 			filename = strings.TrimSpace(filepath.Base(fileURL))
 
 			if filename == "" {
-				fmt.Println("Error: Filename extraction failed.")
-				bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Error: Could not determine file name.")).Do()
+				slog.ErrorContext(ctx, "could not extract object name from URL", "url", fileURL)
+				reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrFileName), nil)))
 				return
 			}
-			fmt.Println("Extracted filename:", filename)
+			slog.DebugContext(ctx, "opening file", "file", filesad, "object", filename)
 
-			fmt.Printf("Filename raw: [%s]\n", filename)
 			// 🔥 Improved file type detection based on the actual filename
 			switch {
 			case strings.HasSuffix(filename, ".txt"):
-				fmt.Println("Detected .txt file") // Debugging
-				fmt.Println("Fetching text from URL:", fileURL)
-				content, err := fetchTextFromURL(fileURL)
+				content, err := fetchTextFromURL(ctx, fileURL)
 				if err != nil {
-					fmt.Println("Error fetching file content:", err) // Debugging
-					bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Error reading file content.")).Do()
+					entry.Err = err
+					slog.ErrorContext(ctx, "error fetching file content", "object", filename, "error", err)
+					reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrReadContent), err)))
 					return
 				}
-				fmt.Println("File content:", content) // Debugging
-				bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage(content)).Do()
-
-			case strings.HasSuffix(filename, ".jpeg"), strings.HasSuffix(filename, ".jpg"), strings.HasSuffix(filename, ".png"):
-				fmt.Println("Detected image file") // Debugging
-				bot.ReplyMessage(event.ReplyToken, linebot.NewImageMessage(fileURL, fileURL)).Do()
+				reply(ctx, event, linebot.NewTextMessage(content))
+
+			case isImageFile(filename):
+				// 🔥 ส่ง rendition ที่ผ่านข้อจำกัดของ LINE แทนไฟล์ต้นฉบับถ้าจำเป็น
+				originalURL, previewURL, err := imageMessageURLs(ctx, fileURL)
+				if errors.Is(err, errImageTooLarge) {
+					entry.Err = err
+					reply(ctx, event, linebot.NewTextMessage(t(ctx, msgImageTooLarge)))
+					return
+				}
+				if err != nil {
+					entry.Err = err
+					slog.ErrorContext(ctx, "error preparing image rendition", "object", filename, "error", err)
+					reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrPrepareImage), err)))
+					return
+				}
+				reply(ctx, event, linebot.NewImageMessage(originalURL, previewURL))
 
 			default:
-				fmt.Println("Unsupported file type") // Debugging
-				bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Unsupported file type.")).Do()
+				entry.Err = errUnsupportedMessage
+				reply(ctx, event, linebot.NewTextMessage(t(ctx, msgUnsupportedFileType)))
 			}
 		case "list":
-			var category string
-			if len(command) < 2 {
-				category = "" // No category specified, list all available categories
-			} else {
-				category = command[1]
+			entry.Target = strings.Join(args, " ")
+			category, byCategory := cmd.Flags["category"]
+			if byCategory {
+				entry.Target = strings.TrimSpace(category + " " + entry.Target)
+			}
+
+			// list #tag1 #tag2: files carrying all the given tags
+			if !byCategory && len(args) > 0 && strings.HasPrefix(args[0], "#") {
+				tags := normalizeTags(args)
+				if len(tags) == 0 {
+					entry.Err = newUsageError(msgNoTags)
+					reply(ctx, event, linebot.NewTextMessage(usageReply(ctx, cmd, entry.Err)))
+					return
+				}
+				files, err := listFilesWithTags(ctx, ownerID, tags)
+				if err != nil {
+					entry.Err = err
+					slog.ErrorContext(ctx, "error listing files by tag", "error", err)
+					reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrListFiles), err)))
+					return
+				}
+				if len(files) == 0 {
+					reply(ctx, event, linebot.NewTextMessage(t(ctx, msgNoFiles)))
+					return
+				}
+				reply(ctx, event, fileListFlex(ctx, "#"+strings.Join(tags, " #"), files))
+				return
+			}
+
+			if (!byCategory && len(args) > 1) || (byCategory && len(args) > 0) {
+				entry.Err = newUsageError(msgTooManyArgs)
+				reply(ctx, event, linebot.NewTextMessage(usageReply(ctx, cmd, entry.Err)))
+				return
+			}
+			if !byCategory && len(args) == 0 {
+				// No category specified, list all available categories
+				categories, err := listCategoriesFromDB(ctx, ownerID)
+				if err != nil {
+					entry.Err = err
+					slog.ErrorContext(ctx, "error listing categories", "error", err)
+					reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrListCategories), err)))
+					return
+				}
+				if len(categories) == 0 {
+					reply(ctx, event, linebot.NewTextMessage(t(ctx, msgNoFiles)))
+					return
+				}
+				reply(ctx, event, linebot.NewTextMessage(t(ctx, msgCategories, strings.Join(categories, "\n"))))
+				return
 			}
 
-			files, err := listFilesFromDB(category) // Function to fetch files from PostgreSQL
+			if !byCategory {
+				category = args[0]
+			}
+			files, err := listFilesFromDB(ctx, ownerID, category) // Function to fetch files from PostgreSQL
 			if err != nil {
-				log.Println("Database query error:", err)
+				entry.Err = err
+				slog.ErrorContext(ctx, "error listing files", "category", category, "error", err)
+				reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrListFiles), err)))
 				return
 			}
 
 			if len(files) == 0 {
-				bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("No files found.")).Do()
+				reply(ctx, event, linebot.NewTextMessage(t(ctx, msgNoFiles)))
 				return
 			}
 
 			// Format the file list
-			fileListMsg := "Files:\n" + strings.Join(files, "\n")
-			bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage(fileListMsg)).Do()
+			reply(ctx, event, fileListFlex(ctx, t(ctx, msgFilesIn, category), files))
 
-		case "rename":
-			if len(command) < 3 {
-				bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Usage: rename <old_filename> <new_filename>")).Do()
+		case "tag":
+			entry.Target = args[0]
+
+			tags := normalizeTags(args[1:])
+			if len(tags) == 0 {
+				entry.Err = newUsageError(msgNoTags)
+				reply(ctx, event, linebot.NewTextMessage(usageReply(ctx, cmd, entry.Err)))
+				return
+			}
+			err := tagFile(ctx, ownerID, args[0], tags)
+			if errors.Is(err, errFileNotFound) {
+				entry.Err = err
+				reply(ctx, event, linebot.NewTextMessage(t(ctx, msgFileNotFound)))
 				return
 			}
+			if err != nil {
+				entry.Err = err
+				slog.ErrorContext(ctx, "error tagging file", "file", args[0], "error", err)
+				reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrTag), err)))
+				return
+			}
+			reply(ctx, event, linebot.NewTextMessage(t(ctx, msgTagged, args[0], "#"+strings.Join(tags, " #"))))
 
-			oldFilename := command[1]
-			newFilename := command[2]
+		case "untag":
+			entry.Target = args[0]
 
-			err := renameFileInDB(oldFilename, newFilename)
+			tags := normalizeTags(args[1:])
+			if len(tags) == 0 {
+				entry.Err = newUsageError(msgNoTags)
+				reply(ctx, event, linebot.NewTextMessage(usageReply(ctx, cmd, entry.Err)))
+				return
+			}
+			removed, err := untagFile(ctx, ownerID, args[0], tags)
+			if errors.Is(err, errFileNotFound) {
+				entry.Err = err
+				reply(ctx, event, linebot.NewTextMessage(t(ctx, msgFileNotFound)))
+				return
+			}
 			if err != nil {
-				log.Println("Rename error:", err)
-				bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Error renaming file.")).Do()
+				entry.Err = err
+				slog.ErrorContext(ctx, "error removing tags", "file", args[0], "error", err)
+				reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrUntag), err)))
 				return
 			}
-			bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("File renamed successfully!")).Do()
-			return
-		case "delete":
-			if len(command) < 2 {
-				bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Usage: delete <filename>")).Do()
+			reply(ctx, event, linebot.NewTextMessage(t(ctx, msgUntagged, removed, args[0])))
+
+		case "share":
+			entry.Target = args[0]
+
+			duration := defaultShareDuration
+			if len(args) > 1 {
+				d, err := parseShareDuration(args[1])
+				if err != nil {
+					entry.Err = err
+					reply(ctx, event, linebot.NewTextMessage(t(ctx, msgInvalidValue, err)))
+					return
+				}
+				duration = d
+			}
+
+			link, expiresAt, err := createShareLink(ctx, ownerID, userID, args[0], duration)
+			switch {
+			case errors.Is(err, errFileNotFound):
+				entry.Err = err
+				reply(ctx, event, linebot.NewTextMessage(t(ctx, msgFileNotFound)))
+				return
+			case errors.Is(err, errFileBroken):
+				entry.Err = err
+				reply(ctx, event, linebot.NewTextMessage(t(ctx, msgFileBroken, args[0])))
+				return
+			case errors.Is(err, errSharingDisabled):
+				entry.Err = err
+				reply(ctx, event, linebot.NewTextMessage(t(ctx, msgSharingDisabled)))
+				return
+			case err != nil:
+				entry.Err = err
+				slog.ErrorContext(ctx, "error creating share link", "file", args[0], "error", err)
+				reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrShare), err)))
 				return
 			}
+			reply(ctx, event, linebot.NewTextMessage(
+				t(ctx, msgShareLink, args[0], expiresAt.Format("2006-01-02 15:04"), link)))
+
+		case "unshare":
+			entry.Target = args[0]
 
-			filename := command[1]
-			fileURL, err := getFileURL(filename)
+			revoked, accesses, err := revokeShareLinks(ctx, ownerID, args[0])
 			if err != nil {
-				fmt.Println("Error:", err)
+				entry.Err = err
+				slog.ErrorContext(ctx, "error revoking share links", "file", args[0], "error", err)
+				reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrUnshare), err)))
+				return
+			}
+			if revoked == 0 {
+				reply(ctx, event, linebot.NewTextMessage(t(ctx, msgNoShareLinks, args[0])))
+				return
+			}
+			reply(ctx, event, linebot.NewTextMessage(
+				t(ctx, msgUnshared, revoked, args[0], accesses)))
+
+		case "rename":
+			entry.Target = args[0] + " -> " + args[1]
+
+			oldFilename := args[0]
+			newFilename := args[1]
+
+			err := renameFileInDB(ctx, ownerID, oldFilename, newFilename)
+			switch {
+			case errors.Is(err, errFileNotFound):
+				entry.Err = err
+				reply(ctx, event, linebot.NewTextMessage(t(ctx, msgFileNotFound)))
+				return
+			case errors.Is(err, errFileExists):
+				entry.Err = err
+				reply(ctx, event, linebot.NewTextMessage(t(ctx, msgNameTaken, newFilename)))
+				return
+			case err != nil:
+				entry.Err = err
+				slog.ErrorContext(ctx, "error renaming file", "file", oldFilename, "new_name", newFilename, "error", err)
+				reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrRename), err)))
+				return
 			}
+			reply(ctx, event, linebot.NewTextMessage(t(ctx, msgRenamed)))
+			return
+
+		case "quota":
+			handleQuotaCommand(ctx, event, entry)
+
+		case "autosave":
+			handleAutosaveCommand(ctx, event, cmd, entry)
+
+		case "admin":
+			handleAdminCommand(ctx, event, cmd, entry)
+
+		case "audit":
+			handleAuditCommand(ctx, event, cmd, entry)
+
+		case "lang":
+			handleLangCommand(ctx, event, cmd, entry)
+
+		case "delete":
+			entry.Target = args[0]
+
+			filename := args[0]
 			// Call function to delete file from R2 & Database
-			err = deleteFile(fileURL, filename)
+			err := deleteFile(ctx, ownerID, filename)
+			if errors.Is(err, errFileNotFound) {
+				entry.Err = err
+				reply(ctx, event, linebot.NewTextMessage(t(ctx, msgFileNotFound)))
+				return
+			}
 			if err != nil {
-				log.Println("Delete error:", err)
-				bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Error deleting file.")).Do()
+				entry.Err = err
+				slog.ErrorContext(ctx, "error deleting file", "file", filename, "error", err)
+				reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrDelete), err)))
 				return
 			}
 
-			bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("File deleted successfully!")).Do()
+			reply(ctx, event, linebot.NewTextMessage(t(ctx, msgDeleted)))
 
 		default:
 			if exists {
 				// ✅ If a filename is set, handle the text as a file upload
-				fileData := []byte(textMessage.Text)
-				txtFilename := filename + ".txt"
-				fileURL, err := uploadToR2(txtFilename, fileData)
+				entry.Command, entry.Target = "upload", filename+".txt"
+				fileData := []byte(text)
+				_, duplicates, err := storeFileContent(ctx, ownerID, filename, ".txt", fileData)
+				entry.Err = err
+				if errors.Is(err, errQuotaExceeded) {
+					reply(ctx, event, linebot.NewTextMessage(quotaExceededReply(ctx, ownerID)))
+					return
+				}
+				if errors.Is(err, errFileExists) {
+					mu.Lock()
+					delete(userFile, session)
+					mu.Unlock()
+					reply(ctx, event, linebot.NewTextMessage(t(ctx, msgFileExists, filename)))
+					return
+				}
 				if err != nil {
-					log.Printf("Error uploading text file to R2: %v", err)
+					slog.ErrorContext(ctx, "error storing text file", "file", filename, "error", err)
+					reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrUpload), err)))
 					return
 				}
-				updateFileURL(filename, fileURL)
 				mu.Lock()
-				delete(userFile, userID)
+				delete(userFile, session)
 				mu.Unlock()
-				bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Upload successful!")).Do()
+				reply(ctx, event, linebot.NewTextMessage(uploadReply(ctx, duplicates)))
 			} else {
-				bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("USAGE:\nupload,open,list,rename")).Do()
+				// ไม่เก็บข้อความที่พิมพ์มา เก็บแค่ว่าเป็นคำสั่งที่ไม่รู้จัก
+				entry.Err = errUsage
+				reply(ctx, event, linebot.NewTextMessage(helpText(ctx, userID)))
 			}
 		}
 		return // ✅ Return after processing text message
@@ -301,146 +683,180 @@ func handleTextMessage(event *linebot.Event, message linebot.Message) {
 	// ✅ Move file handling inside `if exists`
 	if exists {
 		switch msg := message.(type) {
-		case *linebot.ImageMessage, *linebot.FileMessage:
+		case *linebot.ImageMessage, *linebot.FileMessage, *linebot.VideoMessage:
 			// ✅ Call handleFileMessage to process images/files
-			handleFileMessage(event, msg)
+			handleFileMessage(ctx, event, msg)
 		default:
 			// ❌ Reject unsupported messages
-			bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Unsupported message type. Please send text, image, or file.")).Do()
+			reply(ctx, event, linebot.NewTextMessage(t(ctx, msgUnsupportedMessage)))
 		}
 	} else {
-		bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Please use 'upload -category(optional) -filename' first.")).Do()
+		reply(ctx, event, linebot.NewTextMessage(uploadFirstReply(ctx)))
 	}
 }
 
-func handleFileMessage(event *linebot.Event, message linebot.Message) {
-	userID := event.Source.UserID
+func handleFileMessage(ctx context.Context, event *linebot.Event, message linebot.Message) {
+	ownerID := sourceOwnerID(event.Source)
+	session := sessionKey(event.Source)
 
 	mu.Lock()
-	filename, exists := userFile[userID]
+	filename, exists := userFile[session]
 	mu.Unlock()
 
+	entry := newAudit(ctx, event.Source, "upload")
+	entry.Target = filename
+	defer entry.record()
+
 	if !exists {
-		bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Please use 'upload category filename' first.")).Do()
+		entry.Err = errUsage
+		reply(ctx, event, linebot.NewTextMessage(uploadFirstReply(ctx)))
+		return
+	}
+	if !allowCommand(ctx, event.Source, rateClassUpload) {
+		entry.Err = errRateLimited
+		reply(ctx, event, linebot.NewTextMessage(t(ctx, msgRateLimited)))
 		return
 	}
 
-	var fileData []byte
-	var ext string
-
-	switch msg := message.(type) {
-	case *linebot.FileMessage:
-		// ✅ จัดการกับไฟล์ที่แนบมา
-		log.Printf("Received file message: %s", msg.FileName)
-		content, err := bot.GetMessageContent(msg.ID).Do()
-		if err != nil {
-			log.Printf("Error getting file content: %v", err)
-			bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Error retrieving file.")).Do()
-			return
-		}
-		defer content.Content.Close()
-
-		fileData, err = io.ReadAll(content.Content)
-		if err != nil {
-			log.Printf("Error reading file content: %v", err)
-			bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Error reading file.")).Do()
-			return
-		}
-		log.Printf("File size: %d bytes", len(fileData))
-
-		// 🔥 ตรวจสอบไฟล์โดยใช้ Content-Type
-		contentType := http.DetectContentType(fileData)
-		switch contentType {
-		case "image/png":
-			ext = ".png"
-		case "image/jpeg":
-			ext = ".jpeg"
-		default:
-			ext = filepath.Ext(msg.FileName) // ใช้ extension เดิมถ้ารู้จัก
-		}
-
-	case *linebot.ImageMessage:
-		// ✅ จัดการกับรูปภาพที่แนบมา
-		log.Printf("Received image message")
-		content, err := bot.GetMessageContent(msg.ID).Do()
-		if err != nil {
-			log.Printf("Error getting image content: %v", err)
-			bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Error retrieving image.")).Do()
-			return
-		}
-		defer content.Content.Close()
-
-		fileData, err = io.ReadAll(content.Content)
-		if err != nil {
-			log.Printf("Error reading image content: %v", err)
-			bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Error reading image.")).Do()
+	// 📦 Reject uploads that would not fit before downloading them
+	var announcedSize int64
+	if msg, ok := message.(*linebot.FileMessage); ok {
+		announcedSize = int64(msg.FileSize)
+	}
+	if err := checkQuota(ctx, ownerID, announcedSize); err != nil {
+		entry.Err = err
+		if errors.Is(err, errQuotaExceeded) {
+			reply(ctx, event, linebot.NewTextMessage(quotaExceededReply(ctx, ownerID)))
 			return
 		}
-		ext = ".jpeg" // LINE ส่งภาพมาเป็น JPEG เสมอ
+		slog.ErrorContext(ctx, "error checking quota", "error", err)
+	}
 
-	default:
-		bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Unsupported file type. Only images and files are allowed.")).Do()
+	fileData, ext, err := downloadMessageContent(ctx, message)
+	entry.Err = err
+	if errors.Is(err, errUnsupportedMessage) {
+		reply(ctx, event, linebot.NewTextMessage(t(ctx, msgUnsupportedUpload)))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "error getting message content", "error", err)
+		reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrRetrieveFile), err)))
 		return
 	}
 
-	fullFilename := filename + ext
-	log.Printf("Uploading file: %s", fullFilename)
+	slog.InfoContext(ctx, "uploading file", "file", filename+ext, "size", len(fileData))
+	entry.Target = filename + ext
 
-	fileURL, err := uploadToR2(fullFilename, fileData)
+	fileURL, duplicates, err := storeFileContent(ctx, ownerID, filename, ext, fileData)
+	entry.Err = err
+	if errors.Is(err, errQuotaExceeded) {
+		reply(ctx, event, linebot.NewTextMessage(quotaExceededReply(ctx, ownerID)))
+		return
+	}
+	if errors.Is(err, errFileExists) {
+		mu.Lock()
+		delete(userFile, session)
+		mu.Unlock()
+		reply(ctx, event, linebot.NewTextMessage(t(ctx, msgFileExists, filename)))
+		return
+	}
 	if err != nil {
-		log.Printf("Error uploading file to R2: %v", err)
-		bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Error uploading file.")).Do()
+		slog.ErrorContext(ctx, "error storing file", "file", filename+ext, "error", err)
+		reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrUpload), err)))
 		return
 	}
 
-	log.Printf("Uploaded file URL: %s", fileURL)
-	updateFileURL(filename, fileURL)
+	slog.InfoContext(ctx, "uploaded file", "file", filename+ext, "url", fileURL)
 
 	// ✅ อัปเดตและล้างข้อมูลผู้ใช้หลังจากอัปโหลดเสร็จ
 	mu.Lock()
-	delete(userFile, userID)
+	delete(userFile, session)
 	mu.Unlock()
 
-	bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("Upload successful!")).Do()
+	reply(ctx, event, linebot.NewTextMessage(uploadReply(ctx, duplicates)))
 }
 
-func insertFileMetadata(userID, filename, theme string, timestamp string) error {
-	// Convert timestamp string to time.Time
-	createdAt, err := time.Parse("2006-01-02 15:04:05", timestamp)
-	if err != nil {
-		return err
+// downloadMessageContent fetches the content of an image, video or file
+// message from LINE and picks the extension to store it with
+func downloadMessageContent(ctx context.Context, message linebot.Message) ([]byte, string, error) {
+	var messageID string
+	switch msg := message.(type) {
+	case *linebot.FileMessage:
+		slog.DebugContext(ctx, "received file message", "file_name", msg.FileName, "size", msg.FileSize)
+		messageID = msg.ID
+	case *linebot.ImageMessage:
+		slog.DebugContext(ctx, "received image message")
+		messageID = msg.ID
+	case *linebot.VideoMessage:
+		slog.DebugContext(ctx, "received video message")
+		messageID = msg.ID
+	default:
+		return nil, "", errUnsupportedMessage
 	}
 
-	// Create InsertFileMetadataParams
-	params := db.InsertFileMetadataParams{
-		UserID:      userID,
-		FileName:    filename,
-		FileContent: sql.NullString{String: "", Valid: false}, // Initially empty
-		CreatedAt:   createdAt,
-		Theme:       sql.NullString{String: theme, Valid: true},
+	ctx, cancel := context.WithTimeout(ctx, lineContentTimeout)
+	defer cancel()
+
+	content, err := bot.GetMessageContent(messageID).WithContext(ctx).Do()
+	if err != nil {
+		return nil, "", fmt.Errorf("error getting content: %w", err)
 	}
+	defer content.Content.Close()
 
-	// Use sqlc-generated function
-	err = queries.InsertFileMetadata(context.Background(), params)
+	fileData, err := io.ReadAll(content.Content)
 	if err != nil {
-		return err
+		return nil, "", fmt.Errorf("error reading content: %w", err)
 	}
+	slog.DebugContext(ctx, "downloaded message content", "size", len(fileData))
 
-	return nil
+	var ext string
+	switch msg := message.(type) {
+	case *linebot.FileMessage:
+		// 🔥 ตรวจสอบไฟล์โดยใช้ Content-Type
+		contentType := http.DetectContentType(fileData)
+		switch contentType {
+		case "image/png":
+			ext = ".png"
+		case "image/jpeg":
+			ext = ".jpeg"
+		default:
+			ext = filepath.Ext(msg.FileName) // ใช้ extension เดิมถ้ารู้จัก
+		}
+	case *linebot.ImageMessage:
+		ext = ".jpeg" // LINE ส่งภาพมาเป็น JPEG เสมอ
+	case *linebot.VideoMessage:
+		ext = ".mp4" // LINE ส่งวิดีโอมาเป็น MP4
+	}
+
+	return fileData, ext, nil
 }
 
-func updateFileURL(filename, url string) error {
-	// Create UpdateFileURLParams
-	params := db.UpdateFileURLParams{
-		FileContent: sql.NullString{String: url, Valid: true},
-		FileName:    filename,
+// insertFileMetadata creates the pending file row that a following upload
+// attaches its content to. Restarting an unfinished upload is allowed, but an
+// existing file with the same name is not overwritten.
+func insertFileMetadata(ctx context.Context, ownerID, uploadedBy, filename, category string) error {
+	categoryID, err := queries.UpsertCategory(ctx, db.UpsertCategoryParams{
+		OwnerID: ownerID,
+		Name:    category,
+	})
+	if err != nil {
+		return err
 	}
 
 	// Use sqlc-generated function
-	err := queries.UpdateFileURL(context.Background(), params)
+	created, err := queries.CreatePendingFile(ctx, db.CreatePendingFileParams{
+		OwnerID:    ownerID,
+		Name:       filename,
+		CategoryID: sql.NullInt64{Int64: categoryID, Valid: true},
+		UploadedBy: sql.NullString{String: uploadedBy, Valid: uploadedBy != ""},
+		EventID:    eventKey(ctx),
+	})
 	if err != nil {
 		return err
 	}
+	if created == 0 {
+		return errFileExists
+	}
 
 	return nil
 }
@@ -472,12 +888,223 @@ func initR2() (*s3.Client, string, error) {
 	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
 		o.BaseEndpoint = aws.String(baseEndpoint)
 		o.UsePathStyle = true // Required for R2
+		o.APIOptions = append(o.APIOptions, r2Metrics, r2Timeout)
+		o.Retryer = r2Retryer()
 	})
 
 	return s3Client, bucketName, nil
 }
 
-func uploadToR2(filename string, data []byte) (string, error) {
+// storeFileContent saves data as the content of filename. Objects are keyed by
+// the SHA-256 of their content, so identical uploads share a single object.
+// It returns the object URL and the library's other files with the same content.
+func storeFileContent(ctx context.Context, ownerID, filename, ext string, data []byte) (string, []string, error) {
+	sum := sha256.Sum256(data)
+	hash := sql.NullString{String: hex.EncodeToString(sum[:]), Valid: true}
+
+	// 🔁 A retried event finds the content it stored the first time
+	key, err := queries.GetEventFileObject(ctx, db.GetEventFileObjectParams{
+		OwnerID: ownerID,
+		Name:    filename,
+		EventID: eventKey(ctx),
+	})
+	if err == nil {
+		slog.InfoContext(ctx, "content already stored by this event", "file", filename, "object", key)
+		return r2PublicURL(key), nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", nil, fmt.Errorf("failed to look up stored content: %w", err)
+	}
+
+	// ☁️ New or missing content goes to R2 before the transaction, so the
+	// library's usage row is not locked while the bytes are sent
+	uploadedKey := ""
+	existing, err := queries.GetObjectByHash(ctx, hash)
+	switch {
+	case err == nil && existing.Status != objectStatusBroken:
+	case err == nil:
+		uploadedKey = existing.ObjectKey
+	case errors.Is(err, sql.ErrNoRows):
+		uploadedKey = hash.String + ext
+	default:
+		return "", nil, fmt.Errorf("failed to look up content hash: %w", err)
+	}
+	if uploadedKey != "" {
+		if _, err := uploadToR2(ctx, uploadedKey, data); err != nil {
+			return "", nil, err
+		}
+	}
+
+	objectKey, duplicates, err := attachContent(ctx, ownerID, filename, ext, data, hash, uploadedKey)
+	if uploadedKey != "" && (err != nil || objectKey != uploadedKey) {
+		// The upload is not used after all
+		discardObject(ctx, hash.String, uploadedKey)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	observeUpload(http.DetectContentType(data), len(data))
+	return r2PublicURL(objectKey), duplicates, nil
+}
+
+// attachContent records data as the content of filename in one short
+// transaction: it finds or creates the object for hash, counts the file in
+// the library's usage and attaches the object. uploadedKey is the object
+// already uploaded by the caller, if any. It returns the key of the object
+// attached and the library's other files with the same content.
+func attachContent(ctx context.Context, ownerID, filename, ext string, data []byte, hash sql.NullString, uploadedKey string) (string, []string, error) {
+	size := sql.NullInt64{Int64: int64(len(data)), Valid: true}
+	mimeType := sql.NullString{String: http.DetectContentType(data), Valid: true}
+
+	tx, err := dbconn.BeginTx(ctx, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	qtx := instrumentDB(tx)
+
+	// 🔒 Serialize with deletes and uploads of the same content
+	if err := qtx.LockContentHash(ctx, hash.String); err != nil {
+		return "", nil, fmt.Errorf("failed to lock content hash: %w", err)
+	}
+
+	var objectID int64
+	var objectKey string
+	existing, err := qtx.GetObjectByHash(ctx, hash)
+	switch {
+	case err == nil && existing.Status == objectStatusBroken:
+		// 🩹 reconcile found this content missing; the new upload restores it
+		objectID, objectKey = existing.ID, existing.ObjectKey
+		if err := ensureStored(ctx, objectKey, uploadedKey, data); err != nil {
+			return "", nil, err
+		}
+		err := qtx.UpdateObjectStatus(ctx, db.UpdateObjectStatusParams{Status: objectStatusOK, ID: objectID})
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to restore object: %w", err)
+		}
+		slog.InfoContext(ctx, "restored missing object", "sha256", hash.String, "object", objectKey)
+	case err == nil:
+		objectID, objectKey = existing.ID, existing.ObjectKey
+		slog.InfoContext(ctx, "content already stored, reusing object", "sha256", hash.String, "object", objectKey)
+	case errors.Is(err, sql.ErrNoRows):
+		objectKey = hash.String + ext
+		if err := ensureStored(ctx, objectKey, uploadedKey, data); err != nil {
+			return "", nil, err
+		}
+		objectID, err = qtx.InsertObject(ctx, db.InsertObjectParams{
+			ObjectKey: objectKey,
+			Sha256:    hash,
+			Size:      size,
+			MimeType:  mimeType,
+		})
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to save object: %w", err)
+		}
+	default:
+		return "", nil, fmt.Errorf("failed to look up content hash: %w", err)
+	}
+
+	// 📦 Count the file against the library's quota. This locks the usage
+	// row, so only quick statements follow until the commit.
+	if err := reserveQuota(ctx, qtx, ownerID, size.Int64); err != nil {
+		return "", nil, err
+	}
+
+	object := sql.NullInt64{Int64: objectID, Valid: true}
+	attached, err := qtx.AttachFileObject(ctx, db.AttachFileObjectParams{
+		ObjectID:  object,
+		Extension: ext,
+		MimeType:  mimeType,
+		Size:      size,
+		EventID:   eventKey(ctx),
+		OwnerID:   ownerID,
+		Name:      filename,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to save file object: %w", err)
+	}
+	if attached == 0 {
+		// 👥 Another member's upload to the same name finished first
+		return "", nil, errFileExists
+	}
+
+	duplicates, err := qtx.ListDuplicateFiles(ctx, db.ListDuplicateFilesParams{
+		OwnerID:     ownerID,
+		ObjectID:    object,
+		ExcludeName: filename,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to look up duplicates: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", nil, fmt.Errorf("failed to commit file object: %w", err)
+	}
+	return objectKey, duplicates, nil
+}
+
+// ensureStored makes sure key holds data while its content hash is locked.
+// An upload made before the lock may have been removed since by a delete of
+// the same content, so it is checked, and anything else is uploaded now.
+func ensureStored(ctx context.Context, key, uploadedKey string, data []byte) error {
+	if key == uploadedKey {
+		stored, err := objectExists(ctx, key)
+		if err != nil || stored {
+			return err
+		}
+	}
+	_, err := uploadToR2(ctx, key, data)
+	return err
+}
+
+// discardObject deletes key from R2 unless an object row still refers to it.
+// It holds the content hash lock, so an upload of the same content cannot
+// attach to the object while it is being deleted. Failures leave the object
+// as an orphan for reconcile.
+func discardObject(ctx context.Context, hash, key string) {
+	// The event may have failed by running out of time
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), storageTimeout)
+	defer cancel()
+
+	err := func() error {
+		tx, err := dbconn.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+		qtx := instrumentDB(tx)
+
+		if err := qtx.LockContentHash(ctx, hash); err != nil {
+			return fmt.Errorf("failed to lock content hash: %w", err)
+		}
+		existing, err := qtx.GetObjectByHash(ctx, sql.NullString{String: hash, Valid: true})
+		if err == nil && existing.ObjectKey == key {
+			return nil // still in use
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to look up content hash: %w", err)
+		}
+		return deleteFromR2(ctx, key)
+	}()
+	if err != nil {
+		slog.WarnContext(ctx, "could not delete object, leaving it for reconcile", "object", key, "error", err)
+	}
+}
+
+// uploadFirstReply asks for the upload command before a file is sent
+func uploadFirstReply(ctx context.Context) string {
+	return t(ctx, msgUploadFirst, "'"+usageLines(ctx, lookupCommand(commands, "upload"), "")[0]+"'")
+}
+
+// uploadReply builds the success message, mentioning duplicate files if any
+func uploadReply(ctx context.Context, duplicates []string) string {
+	if len(duplicates) == 0 {
+		return t(ctx, msgUploaded)
+	}
+	return t(ctx, msgUploadedDuplicates, strings.Join(duplicates, ", "))
+}
+
+func uploadToR2(ctx context.Context, filename string, data []byte) (string, error) {
 	// Auto-detect file content type
 	contentType := http.DetectContentType(data)
 
@@ -488,23 +1115,33 @@ func uploadToR2(filename string, data []byte) (string, error) {
 		ContentType: aws.String(contentType), // Important for proper file handling
 	}
 
-	_, err := s3Client.PutObject(context.TODO(), input)
+	_, err := s3Client.PutObject(ctx, input)
 	if err != nil {
 		return "", fmt.Errorf("failed to upload file to R2: %v", err)
 	}
 
+	return r2PublicURL(filename), nil
+}
+
+// r2PublicURL returns the public r2.dev URL of an object key
+func r2PublicURL(key string) string {
 	bucketID := "pub-5100b97c44f44bd0b69047096448e186" // Replace with your actual R2.dev bucket ID
-	return fmt.Sprintf("https://%s.r2.dev/%s", bucketID, filename), nil
+	return fmt.Sprintf("https://%s.r2.dev/%s", bucketID, key)
 }
 
-func fetchTextFromURL(fileURL string) (string, error) {
+func fetchTextFromURL(ctx context.Context, fileURL string) (string, error) {
 	client := &http.Client{
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12},
 		},
+		Timeout: storageTimeout,
 	}
 
-	resp, err := client.Get(fileURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("error fetching file: %w", err)
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("error fetching file: %w", err)
 	}
@@ -522,131 +1159,182 @@ func fetchTextFromURL(fileURL string) (string, error) {
 	return string(body), nil
 }
 
-func getFileURL(filename string) (string, error) {
-	// 🔹 Check the database first using sqlc
-	fileContent, err := queries.GetFileURL(context.Background(), filename)
-	if err == nil && fileContent.Valid {
-		// If the file URL is found in the DB, return it
-		return fileContent.String, nil
+// getFileURL returns the public URL of a stored file in ownerID's library
+func getFileURL(ctx context.Context, ownerID, filename string) (string, error) {
+	// 🔹 Look up the file and its object using sqlc
+	file, err := queries.GetFileObject(ctx, db.GetFileObjectParams{
+		OwnerID: ownerID,
+		Name:    filename,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errFileNotFound
 	}
-
-	// If not found in DB or if fileContent is not valid, check R2
-	input := &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucket),
+	if err != nil {
+		return "", err
 	}
 
-	result, err := s3Client.ListObjectsV2(context.TODO(), input)
-	if err != nil {
-		return "", fmt.Errorf("error listing R2 objects: %w", err)
+	// Upload was started but no content has been attached yet
+	if !file.ObjectKey.Valid {
+		return "", errFileNotFound
+	}
+	// reconcile found the object missing or corrupted in R2
+	if file.Status.String == objectStatusBroken {
+		return "", errFileBroken
 	}
 
-	var correctFile string
-	for _, obj := range result.Contents {
-		if strings.HasPrefix(*obj.Key, filename) { // Match filename ignoring extension
-			correctFile = *obj.Key
-			break
+	return r2PublicURL(file.ObjectKey.String), nil
+}
+
+// listR2Objects returns every object in the bucket, following pagination
+func listR2Objects(ctx context.Context, s3Client *s3.Client, bucket string) ([]types.Object, error) {
+	var objects []types.Object
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing R2 objects: %w", err)
 		}
+		objects = append(objects, page.Contents...)
 	}
+	return objects, nil
+}
+
+func listCategoriesFromDB(ctx context.Context, ownerID string) ([]string, error) {
+	return queries.ListCategories(ctx, ownerID)
+}
 
-	if correctFile == "" {
-		return "", fmt.Errorf("file not found in R2")
+func listFilesFromDB(ctx context.Context, ownerID, category string) ([]fileListing, error) {
+	rows, err := queries.ListFilesInCategory(ctx, db.ListFilesInCategoryParams{
+		OwnerID:  ownerID,
+		Category: category,
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// 🔹 Construct the file URL
-	fileURL := fmt.Sprintf("https://%s.r2.dev/%s", bucket, correctFile)
+	files := make([]fileListing, 0, len(rows))
+	for _, row := range rows {
+		files = append(files, fileListing{Name: row.Name, Tags: row.Tags})
+	}
+	return files, nil
+}
 
-	// 🔹 Update the database with the correct URL using sqlc
-	err = updateFileURL(filename, fileURL)
+func renameFileInDB(ctx context.Context, ownerID, oldFilename, newFilename string) error {
+	// Use sqlc-generated function
+	renamed, err := queries.RenameFile(ctx, db.RenameFileParams{
+		NewName: newFilename,
+		OwnerID: ownerID,
+		OldName: oldFilename,
+	})
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation
+		return errFileExists
+	}
 	if err != nil {
-		log.Printf("Warning: Could not update DB with R2 file URL: %v", err)
+		return err
+	}
+	if renamed == 0 {
+		return errFileNotFound
 	}
 
-	// 🔹 Extract filename from URL
-	extractedFilename := filepath.Base(fileURL)
-	fmt.Println("File URL:", fileURL)
-	fmt.Println("Extracted filename:", extractedFilename)
-
-	return fileURL, nil
+	return nil
 }
 
-func listR2Objects(s3Client *s3.Client, bucket string) {
-	input := &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucket),
+func deleteFile(ctx context.Context, ownerID, filename string) error {
+	file, err := queries.GetFileObject(ctx, db.GetFileObjectParams{
+		OwnerID: ownerID,
+		Name:    filename,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return errFileNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read file from DB: %w", err)
 	}
 
-	result, err := s3Client.ListObjectsV2(context.TODO(), input)
+	tx, err := dbconn.BeginTx(ctx, nil)
 	if err != nil {
-		log.Fatalf("Error listing R2 objects: %v", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
+	qtx := instrumentDB(tx)
 
-	for _, obj := range result.Contents {
-		log.Printf("File: %s, Size: %d bytes", *obj.Key, obj.Size)
+	// 🔒 Serialize with uploads of the same content
+	if file.Sha256.Valid {
+		if err := qtx.LockContentHash(ctx, file.Sha256.String); err != nil {
+			return fmt.Errorf("failed to lock content hash: %w", err)
+		}
 	}
-}
-func listFilesFromDB(category string) ([]string, error) {
-	if category == "" {
-		// List all categories
-		categories, err := queries.ListAllCategories(context.Background())
+
+	// 🗑️ Delete from Database using sqlc generated function
+	err = qtx.DeleteFile(ctx, db.DeleteFileParams{OwnerID: ownerID, Name: filename})
+	if err != nil {
+		return fmt.Errorf("failed to delete from DB: %w", err)
+	}
+	if file.ObjectID.Valid {
+		err := qtx.ReleaseUsage(ctx, db.ReleaseUsageParams{Bytes: file.Size.Int64, OwnerID: ownerID})
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("failed to update usage: %w", err)
 		}
+	}
 
-		var result []string
-		for _, cat := range categories {
-			if cat.Valid {
-				result = append(result, cat.String)
-			}
-		}
-		return result, nil
-	} else {
-		// List files in the specified category
-		files, err := queries.ListFilesInCategory(context.Background(), sql.NullString{String: category, Valid: true})
+	// 🗑️ Delete the object only when no other file still references it
+	unreferenced := false
+	if file.ObjectID.Valid {
+		refs, err := qtx.CountObjectReferences(ctx, file.ObjectID)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("failed to count object references: %w", err)
+		}
+		if refs == 0 {
+			if err := qtx.DeleteObject(ctx, file.ObjectID.Int64); err != nil {
+				return fmt.Errorf("failed to delete object from DB: %w", err)
+			}
+			unreferenced = true
+		} else {
+			slog.InfoContext(ctx, "keeping object still referenced by other files", "object", file.ObjectKey.String, "references", refs)
 		}
-		return files, nil
 	}
-}
 
-func renameFileInDB(oldFilename, newFilename string) error {
-	// Create RenameFileParams
-	params := db.RenameFileParams{
-		FileName:   newFilename,
-		FileName_2: oldFilename,
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	// Use sqlc-generated function
-	err := queries.RenameFile(context.Background(), params)
-	if err != nil {
-		return err
+	// The bucket is only touched once the rows are gone for good, and only if
+	// no upload of the same content has recreated the object meanwhile. An
+	// object left behind by a failed delete is an orphan that reconcile removes.
+	if unreferenced {
+		if file.Sha256.Valid {
+			discardObject(ctx, file.Sha256.String, file.ObjectKey.String)
+		} else if err := deleteFromR2(ctx, file.ObjectKey.String); err != nil {
+			slog.WarnContext(ctx, "could not delete object, leaving it for reconcile", "object", file.ObjectKey.String, "error", err)
+		}
 	}
-
-	return nil
+	return nil // ✅ Success
 }
 
-func deleteFile(fileURL, filename string) error {
-	s3Client, bucketName, err := initR2() // ✅ Initialize R2 client
-	if err != nil {
-		return fmt.Errorf("failed to initialize R2: %w", err)
-	}
-
-	// 🔹 Extract filename from URL
-	fileKey := filepath.Base(fileURL) // Ensure consistent naming
-
-	// 🗑️ Delete from R2
-	_, err = s3Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-		Bucket: &bucketName,
-		Key:    &fileKey,
+// deleteFromR2 removes an object together with its cached image renditions
+func deleteFromR2(ctx context.Context, fileKey string) error {
+	_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(fileKey),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to delete from R2: %w", err)
 	}
 
-	// 🗑️ Delete from Database using sqlc generated function
-	err = queries.DeleteFile(context.Background(), filename)
-	if err != nil {
-		return fmt.Errorf("failed to delete from DB: %w", err)
+	// 🗑️ Delete cached image renditions (if any)
+	base := strings.TrimSuffix(fileKey, filepath.Ext(fileKey))
+	for _, key := range []string{renditionPrefix + base + ".jpg", renditionPrefix + base + ".preview.jpg"} {
+		_, err = s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			slog.WarnContext(ctx, "could not delete rendition", "object", key, "error", err)
+		}
 	}
 
-	return nil // ✅ Success
+	return nil
 }
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"Line01/db"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// ภาษาที่บอทตอบได้ เพิ่มภาษาใหม่ได้โดยเพิ่ม catalog ใน catalogs
+const (
+	langEnglish = "en"
+	langThai    = "th"
+)
+
+// defaultLanguage is used when a user has not chosen a language and their
+// LINE profile does not name a supported one (DEFAULT_LANGUAGE)
+var defaultLanguage = langEnglish
+
+// msgKey identifies a reply in the message catalogs
+type msgKey int
+
+// ข้อความตอบกลับทั้งหมด (ข้อความจริงอยู่ใน catalogs)
+const (
+	msgLanguageName msgKey = iota
+	msgCommands
+	msgHelp
+	msgSuspended
+	msgRateLimited
+	msgUploadFirst
+	msgInvalidValue
+	msgRef
+	msgHintTemporary
+	msgHintThrottled
+
+	// command syntax
+	msgUsageLine
+	msgMissingArgs
+	msgTooManyArgs
+	msgUnknownOption
+	msgMissingFlagValue
+	msgUnclosedQuote
+	msgCategoryFlag
+	msgNoTags
+	msgEmptyArg
+	msgArgFilename
+	msgArgCategory
+	msgArgListFilter
+	msgArgOldName
+	msgArgNewName
+	msgArgTag
+	msgArgDuration
+	msgArgLanguage
+	msgArgUserID
+	msgArgOwnerID
+	msgArgReason
+	msgArgQuotaBytes
+	msgArgQuotaFiles
+	msgArgMessage
+	msgArgSince
+
+	// lang
+	msgLanguageCurrent
+	msgLanguageSet
+	msgErrSetLanguage
+
+	// files
+	msgFileNotFound
+	msgFileBroken
+	msgNoFiles
+	msgMoreFiles
+	msgFileExists
+	msgErrSaveMetadata
+	msgSendFile
+	msgErrFileName
+	msgErrReadContent
+	msgErrPrepareImage
+	msgImageTooLarge
+	msgUnsupportedFileType
+	msgErrListFiles
+	msgErrListCategories
+	msgCategories
+	msgFilesIn
+	msgErrTag
+	msgTagged
+	msgErrUntag
+	msgUntagged
+	msgSharingDisabled
+	msgErrShare
+	msgShareLink
+	msgErrUnshare
+	msgNoShareLinks
+	msgUnshared
+	msgNameTaken
+	msgErrRename
+	msgRenamed
+	msgErrDelete
+	msgDeleted
+
+	// uploads
+	msgUploaded
+	msgUploadedDuplicates
+	msgErrUpload
+	msgErrRetrieveFile
+	msgUnsupportedUpload
+	msgUnsupportedMessage
+
+	// quota
+	msgQuotaFullUser
+	msgQuotaFullGroup
+	msgYourStorage
+	msgGroupStorage
+	msgErrReadUsage
+	msgSpace
+	msgFiles
+	msgUnlimited
+	msgUsedOf
+
+	// autosave
+	msgAutosaveGroupsOnly
+	msgErrAutosaveOn
+	msgAutosaveOn
+	msgErrAutosaveOff
+	msgAutosaveOff
+	msgErrReadAutosave
+	msgAutosaveStatusOn
+
+	// admin and audit
+	msgErrStats
+	msgStats
+	msgNoFilesOf
+	msgFilesOf
+	msgAdminNotSuspendable
+	msgErrSuspend
+	msgUserSuspended
+	msgErrUnsuspend
+	msgUserNotSuspended
+	msgUserUnsuspended
+	msgAdminDeleted
+	msgStorageOf
+	msgErrResetQuota
+	msgQuotaReset
+	msgErrSetQuota
+	msgQuotaSet
+	msgErrBroadcast
+	msgBroadcastSent
+	msgErrReadAudit
+	msgNoAuditEntries
+	msgAuditHeader
+)
+
+// catalogs holds the replies of every supported language as fmt formats. A
+// reply missing from a catalog falls back to English.
+var catalogs = map[string]map[msgKey]string{
+	langEnglish: {
+		msgLanguageName:  "English",
+		msgCommands:      "Commands:",
+		msgHelp:          "Use 'upload' to upload\nUse 'open' to open files",
+		msgSuspended:     "Your account has been suspended. Please contact the administrator.",
+		msgRateLimited:   "You're sending commands a little too fast 🙏 Please wait a moment and try again.",
+		msgUploadFirst:   "Please send %s first, then the file.",
+		msgInvalidValue:  "Error: %v",
+		msgRef:           "(ref: %s)",
+		msgHintTemporary: "This looks temporary, please try again in a moment.",
+		msgHintThrottled: "The service is busy right now, please try again in a few minutes.",
+
+		msgUsageLine:        "Usage: %s",
+		msgMissingArgs:      "missing arguments",
+		msgTooManyArgs:      "too many arguments, put names with spaces in quotes",
+		msgUnknownOption:    "unknown option %s",
+		msgMissingFlagValue: "%s needs a value",
+		msgUnclosedQuote:    "missing closing quote",
+		msgCategoryFlag:     "the category goes after -c, e.g. %s",
+		msgNoTags:           "no tag names given",
+		msgEmptyArg:         "names cannot be empty",
+		msgArgFilename:      "filename",
+		msgArgCategory:      "category",
+		msgArgListFilter:    "category | #tag",
+		msgArgOldName:       "old filename",
+		msgArgNewName:       "new filename",
+		msgArgTag:           "tag",
+		msgArgDuration:      "duration, e.g. 1h or 7d",
+		msgArgLanguage:      "th|en",
+		msgArgUserID:        "userID",
+		msgArgOwnerID:       "ownerID",
+		msgArgReason:        "reason",
+		msgArgQuotaBytes:    "bytes|reset",
+		msgArgQuotaFiles:    "files",
+		msgArgMessage:       "message",
+		msgArgSince:         "since, e.g. 24h, 7d or 2006-01-02",
+
+		msgLanguageCurrent: "Language: %s",
+		msgLanguageSet:     "I'll reply in English from now on.",
+		msgErrSetLanguage:  "Error saving your language.",
+
+		msgFileNotFound:        "Error: File not found.",
+		msgFileBroken:          "The stored content of %s is missing or damaged. Upload the same file again under any new name to restore it.",
+		msgNoFiles:             "No files found.",
+		msgMoreFiles:           "...and %d more",
+		msgFileExists:          "Error: a file named %s already exists. Rename or delete it first.",
+		msgErrSaveMetadata:     "Error saving file metadata.",
+		msgSendFile:            "Send file:",
+		msgErrFileName:         "Error: Could not determine file name.",
+		msgErrReadContent:      "Error reading file content.",
+		msgErrPrepareImage:     "Error preparing image.",
+		msgImageTooLarge:       "This image is too large to show in chat. Use share to get a link to it.",
+		msgUnsupportedFileType: "Unsupported file type.",
+		msgErrListFiles:        "Error retrieving files.",
+		msgErrListCategories:   "Error retrieving categories.",
+		msgCategories:          "Categories:\n%s",
+		msgFilesIn:             "Files in %s",
+		msgErrTag:              "Error tagging file.",
+		msgTagged:              "Tagged %s with %s",
+		msgErrUntag:            "Error removing tags.",
+		msgUntagged:            "Removed %d tag(s) from %s",
+		msgSharingDisabled:     "Sharing is not available.",
+		msgErrShare:            "Error creating share link.",
+		msgShareLink:           "Share link for %s (expires %s):\n%s",
+		msgErrUnshare:          "Error revoking share links.",
+		msgNoShareLinks:        "No active share links for %s.",
+		msgUnshared:            "Revoked %d link(s) for %s. They were opened %d time(s).",
+		msgNameTaken:           "Error: a file named %s already exists.",
+		msgErrRename:           "Error renaming file.",
+		msgRenamed:             "File renamed successfully!",
+		msgErrDelete:           "Error deleting file.",
+		msgDeleted:             "File deleted successfully!",
+
+		msgUploaded:           "Upload successful!",
+		msgUploadedDuplicates: "Upload successful!\nNote: the same content is already saved as: %s",
+		msgErrUpload:          "Error uploading file.",
+		msgErrRetrieveFile:    "Error retrieving file.",
+		msgUnsupportedUpload:  "Unsupported file type. Only images, videos and files are allowed.",
+		msgUnsupportedMessage: "Unsupported message type. Please send text, image, or file.",
+
+		msgQuotaFullUser:  "Your storage quota is full. Delete some files or ask an admin for more space. Send 'quota' to see usage.",
+		msgQuotaFullGroup: "This group's storage quota is full. Delete some files or ask an admin for more space. Send 'quota' to see usage.",
+		msgYourStorage:    "Your storage",
+		msgGroupStorage:   "Group storage",
+		msgErrReadUsage:   "Error reading storage usage.",
+		msgSpace:          "Space",
+		msgFiles:          "Files",
+		msgUnlimited:      "unlimited",
+		msgUsedOf:         "%s of %s",
+
+		msgAutosaveGroupsOnly: "Autosave is only available in groups and rooms.",
+		msgErrAutosaveOn:      "Error enabling autosave.",
+		msgAutosaveOn:         "📥 Autosave is on. Images, videos and files posted here will be saved to %s.",
+		msgErrAutosaveOff:     "Error disabling autosave.",
+		msgAutosaveOff:        "Autosave is off.",
+		msgErrReadAutosave:    "Error reading autosave setting.",
+		msgAutosaveStatusOn:   "Autosave is on, saving to %s.",
+
+		msgErrStats:            "Error getting stats.",
+		msgStats:               "📊 Stats\nLibraries: %d\nFiles: %d\nStored objects: %d (%s)\nActive share links: %d\nSuspended users: %d",
+		msgNoFilesOf:           "No files found for %s",
+		msgFilesOf:             "Files of %s",
+		msgAdminNotSuspendable: "Admins cannot be suspended.",
+		msgErrSuspend:          "Error suspending user.",
+		msgUserSuspended:       "User %s suspended.",
+		msgErrUnsuspend:        "Error unsuspending user.",
+		msgUserNotSuspended:    "User %s is not suspended.",
+		msgUserUnsuspended:     "User %s unsuspended.",
+		msgAdminDeleted:        "File deleted: %s/%s",
+		msgStorageOf:           "Storage of %s",
+		msgErrResetQuota:       "Error resetting quota.",
+		msgQuotaReset:          "Quota of %s reset to the default.",
+		msgErrSetQuota:         "Error setting quota.",
+		msgQuotaSet:            "Quota of %s set to %s and %d files (0 = unlimited).",
+		msgErrBroadcast:        "Error sending broadcast.",
+		msgBroadcastSent:       "Broadcast sent.",
+		msgErrReadAudit:        "Error reading audit log.",
+		msgNoAuditEntries:      "No audit entries found.",
+		msgAuditHeader:         "🧾 Latest %d audit entries since %s:",
+	},
+	langThai: {
+		msgLanguageName:  "ภาษาไทย",
+		msgCommands:      "คำสั่ง:",
+		msgHelp:          "พิมพ์ 'upload' เพื่ออัปโหลดไฟล์\nพิมพ์ 'open' เพื่อเปิดไฟล์",
+		msgSuspended:     "บัญชีของคุณถูกระงับการใช้งาน กรุณาติดต่อผู้ดูแลระบบ",
+		msgRateLimited:   "ส่งคำสั่งเร็วเกินไปนิดนึง 🙏 รอสักครู่แล้วลองใหม่นะ",
+		msgUploadFirst:   "กรุณาพิมพ์ %s ก่อน แล้วจึงส่งไฟล์",
+		msgInvalidValue:  "ผิดพลาด: %v",
+		msgRef:           "(อ้างอิง: %s)",
+		msgHintTemporary: "น่าจะเป็นปัญหาชั่วคราว ลองใหม่อีกครั้งในอีกสักครู่",
+		msgHintThrottled: "ระบบกำลังมีผู้ใช้งานมาก ลองใหม่อีกครั้งในอีกไม่กี่นาที",
+
+		msgUsageLine:        "วิธีใช้: %s",
+		msgMissingArgs:      "ระบุข้อมูลไม่ครบ",
+		msgTooManyArgs:      "ระบุข้อมูลเกิน ถ้าชื่อมีเว้นวรรคให้ใส่ในเครื่องหมายคำพูด",
+		msgUnknownOption:    "ไม่รู้จักตัวเลือก %s",
+		msgMissingFlagValue: "%s ต้องระบุค่า",
+		msgUnclosedQuote:    "ไม่มีเครื่องหมายคำพูดปิด",
+		msgCategoryFlag:     "ระบุหมวดหมู่หลัง -c เช่น %s",
+		msgNoTags:           "ไม่ได้ระบุชื่อแท็ก",
+		msgEmptyArg:         "ชื่อต้องไม่ว่าง",
+		msgArgFilename:      "ชื่อไฟล์",
+		msgArgCategory:      "หมวดหมู่",
+		msgArgListFilter:    "หมวดหมู่ | #แท็ก",
+		msgArgOldName:       "ชื่อเดิม",
+		msgArgNewName:       "ชื่อใหม่",
+		msgArgTag:           "แท็ก",
+		msgArgDuration:      "ระยะเวลา เช่น 1h หรือ 7d",
+		msgArgLanguage:      "th|en",
+		msgArgUserID:        "userID",
+		msgArgOwnerID:       "ownerID",
+		msgArgReason:        "เหตุผล",
+		msgArgQuotaBytes:    "ไบต์|reset",
+		msgArgQuotaFiles:    "จำนวนไฟล์",
+		msgArgMessage:       "ข้อความ",
+		msgArgSince:         "ตั้งแต่ เช่น 24h, 7d หรือ 2006-01-02",
+
+		msgLanguageCurrent: "ภาษา: %s",
+		msgLanguageSet:     "ต่อจากนี้จะตอบเป็นภาษาไทย",
+		msgErrSetLanguage:  "บันทึกภาษาไม่สำเร็จ",
+
+		msgFileNotFound:        "ผิดพลาด: ไม่พบไฟล์",
+		msgFileBroken:          "เนื้อหาของไฟล์ %s สูญหายหรือเสียหาย อัปโหลดไฟล์เดิมอีกครั้งด้วยชื่อใหม่เพื่อกู้คืน",
+		msgNoFiles:             "ไม่พบไฟล์",
+		msgMoreFiles:           "...และอีก %d ไฟล์",
+		msgFileExists:          "ผิดพลาด: มีไฟล์ชื่อ %s อยู่แล้ว เปลี่ยนชื่อหรือลบไฟล์นั้นก่อน",
+		msgErrSaveMetadata:     "บันทึกข้อมูลไฟล์ไม่สำเร็จ",
+		msgSendFile:            "ส่งไฟล์มาได้เลย:",
+		msgErrFileName:         "ผิดพลาด: ไม่สามารถระบุชื่อไฟล์ได้",
+		msgErrReadContent:      "อ่านเนื้อหาไฟล์ไม่สำเร็จ",
+		msgErrPrepareImage:     "เตรียมรูปภาพไม่สำเร็จ",
+		msgImageTooLarge:       "รูปนี้ใหญ่เกินกว่าจะแสดงในแชท ใช้คำสั่ง share เพื่อรับลิงก์แทน",
+		msgUnsupportedFileType: "ไม่รองรับไฟล์ประเภทนี้",
+		msgErrListFiles:        "ดึงรายการไฟล์ไม่สำเร็จ",
+		msgErrListCategories:   "ดึงรายการหมวดหมู่ไม่สำเร็จ",
+		msgCategories:          "หมวดหมู่:\n%s",
+		msgFilesIn:             "ไฟล์ใน %s",
+		msgErrTag:              "ติดแท็กไฟล์ไม่สำเร็จ",
+		msgTagged:              "ติดแท็ก %s ด้วย %s แล้ว",
+		msgErrUntag:            "ลบแท็กไม่สำเร็จ",
+		msgUntagged:            "ลบ %d แท็กออกจาก %s แล้ว",
+		msgSharingDisabled:     "ไม่สามารถแชร์ไฟล์ได้ในขณะนี้",
+		msgErrShare:            "สร้างลิงก์แชร์ไม่สำเร็จ",
+		msgShareLink:           "ลิงก์แชร์ของ %s (หมดอายุ %s):\n%s",
+		msgErrUnshare:          "ยกเลิกลิงก์แชร์ไม่สำเร็จ",
+		msgNoShareLinks:        "ไม่มีลิงก์แชร์ที่ใช้งานอยู่ของ %s",
+		msgUnshared:            "ยกเลิก %d ลิงก์ของ %s แล้ว ลิงก์ถูกเปิดไปทั้งหมด %d ครั้ง",
+		msgNameTaken:           "ผิดพลาด: มีไฟล์ชื่อ %s อยู่แล้ว",
+		msgErrRename:           "เปลี่ยนชื่อไฟล์ไม่สำเร็จ",
+		msgRenamed:             "เปลี่ยนชื่อไฟล์เรียบร้อย!",
+		msgErrDelete:           "ลบไฟล์ไม่สำเร็จ",
+		msgDeleted:             "ลบไฟล์เรียบร้อย!",
+
+		msgUploaded:           "อัปโหลดสำเร็จ!",
+		msgUploadedDuplicates: "อัปโหลดสำเร็จ!\nหมายเหตุ: มีเนื้อหาเดียวกันบันทึกไว้แล้วในชื่อ: %s",
+		msgErrUpload:          "อัปโหลดไฟล์ไม่สำเร็จ",
+		msgErrRetrieveFile:    "ดึงไฟล์ไม่สำเร็จ",
+		msgUnsupportedUpload:  "ไม่รองรับไฟล์ประเภทนี้ ส่งได้เฉพาะรูปภาพ วิดีโอ และไฟล์",
+		msgUnsupportedMessage: "ไม่รองรับข้อความประเภทนี้ กรุณาส่งข้อความ รูปภาพ หรือไฟล์",
+
+		msgQuotaFullUser:  "พื้นที่เก็บไฟล์ของคุณเต็มแล้ว ลบไฟล์บางส่วนหรือขอพื้นที่เพิ่มจากผู้ดูแล พิมพ์ 'quota' เพื่อดูการใช้งาน",
+		msgQuotaFullGroup: "พื้นที่เก็บไฟล์ของกลุ่มนี้เต็มแล้ว ลบไฟล์บางส่วนหรือขอพื้นที่เพิ่มจากผู้ดูแล พิมพ์ 'quota' เพื่อดูการใช้งาน",
+		msgYourStorage:    "พื้นที่ของคุณ",
+		msgGroupStorage:   "พื้นที่ของกลุ่ม",
+		msgErrReadUsage:   "อ่านข้อมูลการใช้พื้นที่ไม่สำเร็จ",
+		msgSpace:          "พื้นที่",
+		msgFiles:          "ไฟล์",
+		msgUnlimited:      "ไม่จำกัด",
+		msgUsedOf:         "%s จาก %s",
+
+		msgAutosaveGroupsOnly: "บันทึกอัตโนมัติใช้ได้เฉพาะในกลุ่มและห้องแชทเท่านั้น",
+		msgErrAutosaveOn:      "เปิดบันทึกอัตโนมัติไม่สำเร็จ",
+		msgAutosaveOn:         "📥 เปิดบันทึกอัตโนมัติแล้ว รูปภาพ วิดีโอ และไฟล์ที่ส่งในนี้จะถูกบันทึกไว้ใน %s",
+		msgErrAutosaveOff:     "ปิดบันทึกอัตโนมัติไม่สำเร็จ",
+		msgAutosaveOff:        "ปิดบันทึกอัตโนมัติอยู่",
+		msgErrReadAutosave:    "อ่านการตั้งค่าบันทึกอัตโนมัติไม่สำเร็จ",
+		msgAutosaveStatusOn:   "เปิดบันทึกอัตโนมัติอยู่ บันทึกไว้ใน %s",
+
+		msgErrStats:            "ดึงสถิติไม่สำเร็จ",
+		msgStats:               "📊 สถิติ\nคลังไฟล์: %d\nไฟล์: %d\nออบเจกต์ที่เก็บ: %d (%s)\nลิงก์แชร์ที่ใช้งานอยู่: %d\nผู้ใช้ที่ถูกระงับ: %d",
+		msgNoFilesOf:           "ไม่พบไฟล์ของ %s",
+		msgFilesOf:             "ไฟล์ของ %s",
+		msgAdminNotSuspendable: "ไม่สามารถระงับผู้ดูแลได้",
+		msgErrSuspend:          "ระงับผู้ใช้ไม่สำเร็จ",
+		msgUserSuspended:       "ระงับผู้ใช้ %s แล้ว",
+		msgErrUnsuspend:        "ยกเลิกการระงับไม่สำเร็จ",
+		msgUserNotSuspended:    "ผู้ใช้ %s ไม่ได้ถูกระงับ",
+		msgUserUnsuspended:     "ยกเลิกการระงับผู้ใช้ %s แล้ว",
+		msgAdminDeleted:        "ลบไฟล์แล้ว: %s/%s",
+		msgStorageOf:           "พื้นที่ของ %s",
+		msgErrResetQuota:       "รีเซ็ตโควต้าไม่สำเร็จ",
+		msgQuotaReset:          "รีเซ็ตโควต้าของ %s เป็นค่าเริ่มต้นแล้ว",
+		msgErrSetQuota:         "ตั้งโควต้าไม่สำเร็จ",
+		msgQuotaSet:            "ตั้งโควต้าของ %s เป็น %s และ %d ไฟล์แล้ว (0 = ไม่จำกัด)",
+		msgErrBroadcast:        "ส่งประกาศไม่สำเร็จ",
+		msgBroadcastSent:       "ส่งประกาศแล้ว",
+		msgErrReadAudit:        "อ่าน audit log ไม่สำเร็จ",
+		msgNoAuditEntries:      "ไม่พบรายการ audit",
+		msgAuditHeader:         "🧾 audit ล่าสุด %d รายการตั้งแต่ %s:",
+	},
+}
+
+// initLanguages loads DEFAULT_LANGUAGE
+func initLanguages() {
+	value := os.Getenv("DEFAULT_LANGUAGE")
+	if value == "" {
+		return
+	}
+	lang, ok := supportedLanguage(value)
+	if !ok {
+		fatal("invalid DEFAULT_LANGUAGE", fmt.Errorf("want one of %s, got %q", strings.Join(languageNames(), ", "), value))
+	}
+	defaultLanguage = lang
+}
+
+// t returns the reply key in the language of ctx, formatted with args
+func t(ctx context.Context, key msgKey, args ...any) string {
+	return catalogText(language(ctx), key, args...)
+}
+
+// catalogText returns the reply key in lang, formatted with args
+func catalogText(lang string, key msgKey, args ...any) string {
+	format, ok := catalogs[lang][key]
+	if !ok {
+		format = catalogs[langEnglish][key]
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// withLanguage returns a context whose replies are in lang
+func withLanguage(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, languageKey, lang)
+}
+
+// language returns the reply language carried by ctx
+func language(ctx context.Context) string {
+	if lang, ok := ctx.Value(languageKey).(string); ok {
+		return lang
+	}
+	return defaultLanguage
+}
+
+// supportedLanguage maps a language tag such as "th" or "en-US" to a
+// language with a catalog
+func supportedLanguage(tag string) (string, bool) {
+	base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	_, ok := catalogs[base]
+	return base, ok
+}
+
+// languageNames lists the languages with a catalog
+func languageNames() []string {
+	return slices.Sorted(maps.Keys(catalogs))
+}
+
+// userLanguage returns the language to reply to the sender of an event in:
+// the one they chose with lang, or else the language of their LINE profile,
+// which is remembered so the profile is only fetched once
+func userLanguage(ctx context.Context, source *linebot.EventSource) string {
+	userID := source.UserID
+	if userID == "" {
+		return defaultLanguage
+	}
+
+	lang, err := queries.GetUserLanguage(ctx, userID)
+	if err == nil {
+		if lang, ok := supportedLanguage(lang); ok {
+			return lang
+		}
+		return defaultLanguage
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		slog.ErrorContext(ctx, "error reading user language", "user_id", userID, "error", err)
+		return defaultLanguage
+	}
+
+	// 🌐 ยังไม่เคยตั้งภาษา ใช้ภาษาจากโปรไฟล์ LINE
+	lang = defaultLanguage
+	profile, err := bot.GetProfile(userID).WithContext(ctx).Do()
+	var apiErr *linebot.APIError
+	switch {
+	case err == nil:
+		if profileLang, ok := supportedLanguage(profile.Language); ok {
+			lang = profileLang
+		}
+	case errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound:
+		// Only users who added the bot as a friend have a readable profile
+	default:
+		slog.WarnContext(ctx, "could not get profile language", "user_id", userID, "error", err)
+		return lang
+	}
+
+	err = queries.InitUserLanguage(ctx, db.InitUserLanguageParams{UserID: userID, Language: lang})
+	if err != nil {
+		slog.ErrorContext(ctx, "error saving user language", "user_id", userID, "error", err)
+	}
+	return lang
+}
+
+// handleLangCommand shows or sets the sender's reply language: lang [th|en]
+func handleLangCommand(ctx context.Context, event *linebot.Event, cmd command, entry *auditEntry) {
+	if len(cmd.Args) == 0 {
+		reply(ctx, event, linebot.NewTextMessage(
+			t(ctx, msgLanguageCurrent, t(ctx, msgLanguageName))+"\n"+usageReply(ctx, cmd, nil)))
+		return
+	}
+	entry.Target = cmd.Args[0]
+	lang, ok := supportedLanguage(cmd.Args[0])
+	if !ok || event.Source.UserID == "" {
+		entry.Err = errUsage
+		reply(ctx, event, linebot.NewTextMessage(usageReply(ctx, cmd, newUsageError(msgUnknownOption, cmd.Args[0]))))
+		return
+	}
+
+	err := queries.SetUserLanguage(ctx, db.SetUserLanguageParams{UserID: event.Source.UserID, Language: lang})
+	if err != nil {
+		entry.Err = err
+		slog.ErrorContext(ctx, "error saving user language", "error", err)
+		reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrSetLanguage), err)))
+		return
+	}
+	ctx = withLanguage(ctx, lang)
+	reply(ctx, event, linebot.NewTextMessage(t(ctx, msgLanguageSet)))
+}
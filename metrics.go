@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"Line01/db"
+
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace prefixes every metric exported on /metrics
+const metricsNamespace = "line01"
+
+var (
+	commandsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "commands_total",
+		Help:      "Handled commands by command and result (ok, error, invalid, denied, limited).",
+	}, []string{"command", "result"})
+
+	duplicateEventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "duplicate_events_total",
+		Help:      "Webhook events skipped because they were already handled, by the isRedelivery flag.",
+	}, []string{"redelivery"})
+
+	repliesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "replies_total",
+		Help:      "Messages sent in response to events by method (reply or push) and result.",
+	}, []string{"method", "result"})
+
+	retriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "retries_total",
+		Help:      "Retried calls by dependency (line, postgres or r2).",
+	}, []string{"dependency"})
+
+	errorRepliesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "error_replies_total",
+		Help:      "Errors reported to users by category (temporary, throttled or internal).",
+	}, []string{"category"})
+
+	uploadBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upload_bytes_total",
+		Help:      "Bytes of uploaded files by MIME type.",
+	}, []string{"mime_type"})
+
+	lineRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "line_api_request_duration_seconds",
+		Help:      "Latency of LINE Messaging API requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of Postgres queries by query name and result.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"query", "result"})
+
+	r2RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "r2_request_duration_seconds",
+		Help:      "Latency of R2 operations by operation and result, including retries.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"operation", "result"})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "pending_upload_sessions",
+		Help:      "Upload sessions waiting for a file.",
+	}, func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return float64(len(userFile))
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queued_events",
+		Help:      "Webhook events waiting for an event worker.",
+	}, func() float64 {
+		if eventWorkers == nil {
+			return 0
+		}
+		return float64(eventWorkers.queued())
+	})
+)
+
+// initMetrics registers the connection pool statistics of dbconn
+func initMetrics() {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(dbconn, metricsNamespace))
+}
+
+// metricResult labels the outcome of a call for the latency histograms
+func metricResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// observeUpload counts the bytes of an uploaded file. Parameters such as
+// "; charset=utf-8" are dropped to keep the label set small.
+func observeUpload(mimeType string, size int) {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	uploadBytesTotal.WithLabelValues(strings.TrimSpace(mimeType)).Add(float64(size))
+}
+
+// lineHTTPClient returns the HTTP client used for LINE API calls, retrying
+// transient failures and timing every attempt
+func lineHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: lineRetryTransport{
+			next: promhttp.InstrumentRoundTripperDuration(lineRequestDuration, http.DefaultTransport),
+		},
+	}
+}
+
+// r2Metrics adds a middleware to the S3 client that times every operation
+func r2Metrics(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("R2Metrics",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			start := time.Now()
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			r2RequestDuration.WithLabelValues(middleware.GetOperationName(ctx), metricResult(err)).
+				Observe(time.Since(start).Seconds())
+			return out, metadata, err
+		}), middleware.Before)
+}
+
+// timedDB wraps the connection or transaction used by the generated queries
+// and times every query by its sqlc name. Statements outside a transaction
+// are retried on transient errors; inside one, a failed statement aborts the
+// whole transaction, so only the caller can retry.
+type timedDB struct {
+	db.DBTX
+	retry bool
+}
+
+// instrumentDB returns queries that run on conn and record their latency
+func instrumentDB(conn db.DBTX) *db.Queries {
+	_, isPool := conn.(*sql.DB)
+	return db.New(timedDB{DBTX: conn, retry: isPool})
+}
+
+func (t timedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := t.run(ctx, query, func() (err error) {
+		result, err = t.DBTX.ExecContext(ctx, query, args...)
+		return err
+	})
+	return result, err
+}
+
+func (t timedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := t.run(ctx, query, func() (err error) {
+		rows, err = t.DBTX.QueryContext(ctx, query, args...)
+		return err
+	})
+	return rows, err
+}
+
+func (t timedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	var row *sql.Row
+	t.run(ctx, query, func() error {
+		row = t.DBTX.QueryRowContext(ctx, query, args...)
+		return row.Err()
+	})
+	return row
+}
+
+// run times fn, retrying it under postgresRetry when allowed
+func (t timedDB) run(ctx context.Context, query string, fn func() error) error {
+	start := time.Now()
+	var err error
+	if t.retry {
+		err = postgresRetry.do(ctx, isRetryableDBError, fn)
+	} else {
+		err = fn()
+	}
+	observeQuery(query, start, err)
+	return err
+}
+
+// observeQuery records the latency of a query. sqlc starts every query with
+// "-- name: <Name> :<kind>", which gives a stable label.
+func observeQuery(query string, start time.Time, err error) {
+	name := "other"
+	if rest, ok := strings.CutPrefix(query, "-- name: "); ok {
+		if fields := strings.Fields(rest); len(fields) > 0 {
+			name = fields[0]
+		}
+	}
+	dbQueryDuration.WithLabelValues(name, metricResult(err)).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+
+	"Line01/migrations"
+)
+
+// migrationLockID is the Postgres advisory lock key held while migrating, so
+// that replicas starting at the same time apply migrations one at a time.
+const migrationLockID = 0x4c494e45 // "LINE"
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// loadMigrations reads the embedded migrations, ordered by version
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrations.FS, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		filename := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(filename, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(filename, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		prefix, rest, ok := strings.Cut(filename, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid migration filename %q", filename)
+		}
+		body, err := fs.ReadFile(migrations.FS, filename)
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &migration{version: version, name: strings.TrimSuffix(rest, "."+direction+".sql")}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	var result []migration
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up file", m.version, m.name)
+		}
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].version < result[j].version })
+	return result, nil
+}
+
+// withMigrationLock runs fn on a dedicated connection holding the migration
+// advisory lock, after making sure the schema_migrations table exists.
+func withMigrationLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := dbconn.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring connection: %w", err)
+	}
+	defer conn.Close()
+
+	// Migrations and waiting for another instance's lock may take longer than
+	// QUERY_TIMEOUT; RESET restores the connection's default before it goes
+	// back to the pool
+	if _, err := conn.ExecContext(ctx, "SET statement_timeout = 0"); err != nil {
+		return fmt.Errorf("error disabling statement timeout: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "RESET statement_timeout")
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("error acquiring migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			slog.Warn("could not release migration lock", "error", err)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+// appliedMigrations returns the set of versions recorded in schema_migrations
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]bool, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// migrateUp applies every pending migration, each in its own transaction
+func migrateUp(ctx context.Context) error {
+	all, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range all {
+			if applied[m.version] {
+				continue
+			}
+			slog.Info("applying migration", "version", m.version, "name", m.name)
+			err := runMigration(ctx, conn, m.up,
+				"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.version, m.name)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", m.version, m.name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// migrateDown rolls back the most recent steps applied migrations
+func migrateDown(ctx context.Context, steps int) error {
+	all, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(all) - 1; i >= 0 && steps > 0; i-- {
+			m := all[i]
+			if !applied[m.version] {
+				continue
+			}
+			if m.down == "" {
+				return fmt.Errorf("migration %04d_%s has no down file", m.version, m.name)
+			}
+			slog.Info("reverting migration", "version", m.version, "name", m.name)
+			err := runMigration(ctx, conn, m.down,
+				"DELETE FROM schema_migrations WHERE version = $1", m.version)
+			if err != nil {
+				return fmt.Errorf("reverting %04d_%s failed: %w", m.version, m.name, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// runMigration executes a migration body and its bookkeeping statement atomically
+func runMigration(ctx context.Context, conn *sql.Conn, body, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// printMigrationStatus lists every known migration and whether it is applied
+func printMigrationStatus(ctx context.Context) error {
+	all, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range all {
+			state := "pending"
+			if applied[m.version] {
+				state = "applied"
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.version, m.name, state)
+		}
+		return nil
+	})
+}
+
+// runMigrate handles the migrate subcommand: migrate [up | down [n] | status]
+func runMigrate(args []string) error {
+	ctx := context.Background()
+	if len(args) == 0 {
+		return migrateUp(ctx)
+	}
+
+	switch args[0] {
+	case "up":
+		return migrateUp(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+			steps = n
+		}
+		return migrateDown(ctx, steps)
+	case "status":
+		return printMigrationStatus(ctx)
+	default:
+		return fmt.Errorf("usage: migrate [up | down [n] | status]")
+	}
+}
@@ -0,0 +1,14 @@
+-- Baseline: the line_01 table as it existed before versioned migrations.
+-- Written idempotently so it can be applied to databases created by hand.
+CREATE TABLE IF NOT EXISTS line_01 (
+    id SERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    file_name TEXT NOT NULL,
+    file_content TEXT,
+    created_at TIMESTAMP NOT NULL,
+    theme TEXT
+);
+
+ALTER TABLE line_01 ADD COLUMN IF NOT EXISTS content_hash TEXT;
+ALTER TABLE line_01 ADD COLUMN IF NOT EXISTS file_size BIGINT;
+ALTER TABLE line_01 ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'ok';
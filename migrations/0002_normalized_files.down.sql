@@ -0,0 +1,29 @@
+CREATE TABLE line_01 (
+    id SERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    file_name TEXT NOT NULL,
+    file_content TEXT,
+    created_at TIMESTAMP NOT NULL,
+    theme TEXT,
+    content_hash TEXT,
+    file_size BIGINT,
+    status TEXT NOT NULL DEFAULT 'ok'
+);
+
+INSERT INTO line_01 (user_id, file_name, file_content, created_at, theme, content_hash, file_size, status)
+SELECT f.user_id,
+       f.name,
+       'https://pub-5100b97c44f44bd0b69047096448e186.r2.dev/' || o.object_key,
+       f.created_at,
+       c.name,
+       o.sha256,
+       f.size,
+       COALESCE(o.status, 'ok')
+FROM files f
+LEFT JOIN categories c ON c.id = f.category_id
+LEFT JOIN objects o ON o.id = f.object_id
+ORDER BY f.id;
+
+DROP TABLE files;
+DROP TABLE categories;
+DROP TABLE objects;
@@ -0,0 +1,88 @@
+-- Normalized schema: stored objects, per-user categories and files.
+
+CREATE TABLE objects (
+    id BIGSERIAL PRIMARY KEY,
+    object_key TEXT NOT NULL UNIQUE,
+    sha256 TEXT UNIQUE,
+    size BIGINT,
+    mime_type TEXT,
+    status TEXT NOT NULL DEFAULT 'ok',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE categories (
+    id BIGSERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (user_id, name)
+);
+
+CREATE TABLE files (
+    id BIGSERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    folder TEXT NOT NULL DEFAULT '/',
+    name TEXT NOT NULL,
+    extension TEXT NOT NULL DEFAULT '',
+    mime_type TEXT,
+    size BIGINT,
+    category_id BIGINT REFERENCES categories (id) ON DELETE SET NULL,
+    object_id BIGINT REFERENCES objects (id) ON DELETE RESTRICT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (user_id, folder, name)
+);
+
+CREATE INDEX files_category_id_name_idx ON files (category_id, name);
+CREATE INDEX files_object_id_idx ON files (object_id);
+CREATE INDEX files_user_id_created_at_idx ON files (user_id, created_at DESC);
+
+-- Data migration from line_01. Objects are keyed by the last path segment of
+-- the stored URL; when several objects share a hash only the first keeps it.
+INSERT INTO objects (object_key, sha256, size, mime_type, status, created_at)
+SELECT object_key,
+       CASE WHEN row_number() OVER (PARTITION BY content_hash ORDER BY object_key) = 1 THEN content_hash END,
+       file_size,
+       CASE lower(substring(object_key FROM '\.[^.]*$'))
+           WHEN '.jpeg' THEN 'image/jpeg'
+           WHEN '.jpg' THEN 'image/jpeg'
+           WHEN '.png' THEN 'image/png'
+           WHEN '.gif' THEN 'image/gif'
+           WHEN '.webp' THEN 'image/webp'
+           WHEN '.pdf' THEN 'application/pdf'
+           WHEN '.txt' THEN 'text/plain; charset=utf-8'
+       END,
+       status,
+       created_at
+FROM (
+    SELECT DISTINCT ON (regexp_replace(file_content, '^.*/', ''))
+           regexp_replace(file_content, '^.*/', '') AS object_key,
+           content_hash, file_size, status, created_at
+    FROM line_01
+    WHERE file_content IS NOT NULL AND file_content <> ''
+    ORDER BY regexp_replace(file_content, '^.*/', ''), content_hash NULLS LAST, id
+) legacy;
+
+INSERT INTO categories (user_id, name, created_at)
+SELECT user_id, COALESCE(NULLIF(theme, ''), 'default'), min(created_at)
+FROM line_01
+GROUP BY user_id, COALESCE(NULLIF(theme, ''), 'default');
+
+-- Duplicate (user_id, file_name) rows collapse to the most recent one.
+INSERT INTO files (user_id, name, extension, mime_type, size, category_id, object_id, created_at, updated_at)
+SELECT DISTINCT ON (l.user_id, l.file_name)
+       l.user_id,
+       l.file_name,
+       COALESCE(substring(o.object_key FROM '\.[^.]*$'), ''),
+       o.mime_type,
+       COALESCE(l.file_size, o.size),
+       c.id,
+       o.id,
+       l.created_at,
+       l.created_at
+FROM line_01 l
+JOIN categories c ON c.user_id = l.user_id AND c.name = COALESCE(NULLIF(l.theme, ''), 'default')
+LEFT JOIN objects o ON o.object_key = regexp_replace(l.file_content, '^.*/', '')
+ORDER BY l.user_id, l.file_name, l.id DESC;
+
+DROP TABLE line_01;
@@ -0,0 +1,2 @@
+DROP TABLE file_tags;
+DROP TABLE tags;
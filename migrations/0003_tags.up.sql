@@ -0,0 +1,16 @@
+-- Free-form tags; a file can carry any number of them.
+
+CREATE TABLE tags (
+    id BIGSERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    UNIQUE (user_id, name)
+);
+
+CREATE TABLE file_tags (
+    file_id BIGINT NOT NULL REFERENCES files (id) ON DELETE CASCADE,
+    tag_id BIGINT NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+    PRIMARY KEY (file_id, tag_id)
+);
+
+CREATE INDEX file_tags_tag_id_idx ON file_tags (tag_id);
@@ -0,0 +1,14 @@
+-- Expiring share links for individual files.
+
+CREATE TABLE shares (
+    id TEXT PRIMARY KEY,
+    file_id BIGINT NOT NULL REFERENCES files (id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    access_count BIGINT NOT NULL DEFAULT 0,
+    last_accessed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX shares_file_id_idx ON shares (file_id);
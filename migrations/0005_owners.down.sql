@@ -0,0 +1,12 @@
+-- Files owned by groups or rooms keep their group/room ID as user_id.
+
+ALTER TABLE tags RENAME CONSTRAINT tags_owner_id_name_key TO tags_user_id_name_key;
+ALTER TABLE tags RENAME COLUMN owner_id TO user_id;
+
+ALTER TABLE categories RENAME CONSTRAINT categories_owner_id_name_key TO categories_user_id_name_key;
+ALTER TABLE categories RENAME COLUMN owner_id TO user_id;
+
+ALTER TABLE files DROP COLUMN uploaded_by;
+ALTER INDEX files_owner_id_created_at_idx RENAME TO files_user_id_created_at_idx;
+ALTER TABLE files RENAME CONSTRAINT files_owner_id_folder_name_key TO files_user_id_folder_name_key;
+ALTER TABLE files RENAME COLUMN owner_id TO user_id;
@@ -0,0 +1,14 @@
+-- Libraries can belong to a user, a group or a room: user_id becomes owner_id
+-- (the LINE user, group or room ID) and files remember who uploaded them.
+
+ALTER TABLE files RENAME COLUMN user_id TO owner_id;
+ALTER TABLE files RENAME CONSTRAINT files_user_id_folder_name_key TO files_owner_id_folder_name_key;
+ALTER INDEX files_user_id_created_at_idx RENAME TO files_owner_id_created_at_idx;
+ALTER TABLE files ADD COLUMN uploaded_by TEXT;
+UPDATE files SET uploaded_by = owner_id;
+
+ALTER TABLE categories RENAME COLUMN user_id TO owner_id;
+ALTER TABLE categories RENAME CONSTRAINT categories_user_id_name_key TO categories_owner_id_name_key;
+
+ALTER TABLE tags RENAME COLUMN user_id TO owner_id;
+ALTER TABLE tags RENAME CONSTRAINT tags_user_id_name_key TO tags_owner_id_name_key;
@@ -0,0 +1 @@
+DROP TABLE autosave_settings;
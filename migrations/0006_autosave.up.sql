@@ -0,0 +1,9 @@
+-- Per-group opt-in for archiving every media message posted in the chat.
+
+CREATE TABLE autosave_settings (
+    owner_id TEXT PRIMARY KEY,
+    enabled BOOLEAN NOT NULL DEFAULT false,
+    category TEXT NOT NULL DEFAULT 'default',
+    updated_by TEXT,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,22 @@
+-- Operator tooling: suspended users and an append-only audit trail.
+
+CREATE TABLE suspended_users (
+    user_id TEXT PRIMARY KEY,
+    reason TEXT,
+    suspended_by TEXT NOT NULL,
+    suspended_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    source_id TEXT NOT NULL,
+    command TEXT NOT NULL,
+    target TEXT,
+    result TEXT NOT NULL,
+    error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX audit_log_user_id_created_at_idx ON audit_log (user_id, created_at);
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);
@@ -0,0 +1,2 @@
+DROP TABLE storage_usage;
+DROP TABLE quotas;
@@ -0,0 +1,23 @@
+-- Storage quotas: per-library overrides of the configured defaults and the
+-- usage counters they are enforced against.
+
+CREATE TABLE quotas (
+    owner_id TEXT PRIMARY KEY,
+    max_bytes BIGINT,
+    max_files BIGINT,
+    updated_by TEXT,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE storage_usage (
+    owner_id TEXT PRIMARY KEY,
+    bytes BIGINT NOT NULL DEFAULT 0,
+    files BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO storage_usage (owner_id, bytes, files)
+SELECT owner_id, COALESCE(SUM(size), 0), COUNT(*)
+FROM files
+WHERE object_id IS NOT NULL
+GROUP BY owner_id;
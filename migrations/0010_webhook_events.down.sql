@@ -0,0 +1,2 @@
+ALTER TABLE files DROP COLUMN event_id;
+DROP TABLE webhook_events;
@@ -0,0 +1,14 @@
+-- Webhook events that have been handled, so redelivered events run only once.
+
+CREATE TABLE webhook_events (
+    event_id TEXT PRIMARY KEY,
+    claimed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    completed_at TIMESTAMPTZ,
+    attempts INT NOT NULL DEFAULT 1
+);
+
+CREATE INDEX webhook_events_claimed_at_idx ON webhook_events (claimed_at);
+
+-- The event that last created or stored each file, so a retried event can
+-- recognise its own earlier work instead of repeating it.
+ALTER TABLE files ADD COLUMN event_id TEXT;
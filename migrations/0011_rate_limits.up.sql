@@ -0,0 +1,7 @@
+-- Token buckets for rate limiting, shared by every replica.
+
+CREATE TABLE rate_limit_buckets (
+    bucket_key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
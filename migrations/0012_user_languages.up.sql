@@ -0,0 +1,8 @@
+-- The language each user gets replies in: chosen with the lang command, or
+-- taken from their LINE profile the first time they write.
+
+CREATE TABLE user_languages (
+    user_id TEXT PRIMARY KEY,
+    language TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,16 @@
+-- Work saved at shutdown and picked up again on the next start: events that
+-- were acknowledged but never handled, and uploads waiting for their file.
+
+CREATE TABLE pending_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_id TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    redelivery BOOLEAN NOT NULL DEFAULT false,
+    saved_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE upload_sessions (
+    session_key TEXT PRIMARY KEY,
+    filename TEXT NOT NULL,
+    saved_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,10 @@
+// Package migrations embeds the numbered SQL schema migrations.
+//
+// Each migration is a pair of files named NNNN_description.up.sql and
+// NNNN_description.down.sql. sqlc reads the up migrations as the schema.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
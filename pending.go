@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"Line01/db"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// Webhooks are acknowledged before their events are handled, so LINE never
+// redelivers an event that was still queued when the server stopped. Those
+// events and the uploads waiting for their file are saved at shutdown and
+// picked up again on the next start. Events that were already running when
+// shutdown gave up are cancelled instead; their users are told it timed out.
+
+// savePendingEvent stores a queued event that will not be handled before
+// shutdown
+func savePendingEvent(job eventJob) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(job.ctx), replyTimeout)
+	defer cancel()
+
+	payload, err := json.Marshal(job.event)
+	if err == nil {
+		err = queries.SavePendingEvent(ctx, db.SavePendingEventParams{
+			EventID:    eventID(ctx),
+			Payload:    payload,
+			Redelivery: job.redelivery,
+		})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "could not save unhandled event, it is lost", "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "saved unhandled event for the next start")
+}
+
+// replayPendingEvents queues the events saved by the previous shutdown
+func replayPendingEvents(ctx context.Context) {
+	saved, err := queries.TakePendingEvents(ctx)
+	if err != nil {
+		slog.Error("error loading saved events", "error", err)
+		return
+	}
+	for _, row := range saved {
+		ctx := withEventID(eventsCtx, row.EventID)
+		event := &linebot.Event{}
+		if err := json.Unmarshal(row.Payload, event); err != nil {
+			slog.ErrorContext(ctx, "dropping saved event that cannot be read", "error", err)
+			continue
+		}
+		if err := eventWorkers.enqueue(ctx, event, row.Redelivery); err != nil {
+			slog.ErrorContext(ctx, "could not queue saved event", "error", err)
+			savePendingEvent(eventJob{ctx: ctx, event: event, redelivery: row.Redelivery})
+		}
+	}
+	if len(saved) > 0 {
+		slog.Info("replaying events saved at shutdown", "count", len(saved))
+	}
+}
+
+// saveUploadSessions stores the uploads still waiting for their file
+func saveUploadSessions(ctx context.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+	for session, filename := range userFile {
+		err := queries.SaveUploadSession(ctx, db.SaveUploadSessionParams{SessionKey: session, Filename: filename})
+		if err != nil {
+			slog.Error("could not save pending upload, it is lost", "session", session, "file", filename, "error", err)
+		}
+	}
+	if len(userFile) > 0 {
+		slog.Info("saved pending uploads for the next start", "count", len(userFile))
+	}
+}
+
+// restoreUploadSessions loads the uploads saved by the previous shutdown
+func restoreUploadSessions(ctx context.Context) {
+	saved, err := queries.TakeUploadSessions(ctx)
+	if err != nil {
+		slog.Error("error loading saved uploads", "error", err)
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, row := range saved {
+		userFile[row.SessionKey] = row.Filename
+	}
+	if len(saved) > 0 {
+		slog.Info("restored pending uploads", "count", len(saved))
+	}
+}
@@ -1,21 +1,284 @@
--- name: InsertFileMetadata :exec
-INSERT INTO line_01 (user_id, file_name, file_content, created_at, theme) 
-VALUES ($1, $2, $3, $4, $5);
+-- name: UpsertCategory :one
+INSERT INTO categories (owner_id, name)
+VALUES ($1, $2)
+ON CONFLICT (owner_id, name) DO UPDATE SET name = EXCLUDED.name
+RETURNING id;
 
--- name: UpdateFileURL :exec
-UPDATE line_01 SET file_content = $1 WHERE file_name = $2;
+-- name: CreatePendingFile :execrows
+INSERT INTO files (owner_id, name, category_id, uploaded_by, event_id)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (owner_id, folder, name) DO UPDATE
+SET category_id = EXCLUDED.category_id, uploaded_by = EXCLUDED.uploaded_by, updated_at = now()
+WHERE files.object_id IS NULL OR files.event_id = EXCLUDED.event_id;
 
--- name: GetFileURL :one
-SELECT file_content FROM line_01 WHERE file_name = $1;
+-- name: AttachFileObject :execrows
+UPDATE files
+SET object_id = $1, extension = $2, mime_type = $3, size = $4, event_id = $5, updated_at = now()
+WHERE owner_id = $6 AND folder = '/' AND name = $7 AND (object_id IS NULL OR event_id = $5);
 
--- name: ListAllCategories :many
-SELECT DISTINCT theme FROM line_01;
+-- name: DeletePendingFile :exec
+DELETE FROM files
+WHERE owner_id = $1 AND folder = '/' AND name = $2 AND uploaded_by IS NOT DISTINCT FROM $3 AND object_id IS NULL;
+
+-- name: GetEventFileObject :one
+SELECT o.object_key FROM files f
+JOIN objects o ON o.id = f.object_id
+WHERE f.owner_id = $1 AND f.folder = '/' AND f.name = $2 AND f.event_id = $3;
+
+-- name: GetFileObject :one
+SELECT f.id, f.extension, f.mime_type, f.size, f.object_id, o.object_key, o.sha256, o.status
+FROM files f
+LEFT JOIN objects o ON o.id = f.object_id
+WHERE f.owner_id = $1 AND f.folder = '/' AND f.name = $2;
+
+-- name: GetObjectByHash :one
+SELECT id, object_key, status FROM objects WHERE sha256 = $1;
+
+-- name: InsertObject :one
+INSERT INTO objects (object_key, sha256, size, mime_type)
+VALUES ($1, $2, $3, $4)
+RETURNING id;
+
+-- name: ListDuplicateFiles :many
+SELECT name FROM files
+WHERE owner_id = sqlc.arg(owner_id) AND object_id = sqlc.arg(object_id) AND name <> sqlc.arg(exclude_name)
+ORDER BY name;
+
+-- name: CountObjectReferences :one
+SELECT COUNT(*) FROM files WHERE object_id = $1;
+
+-- name: DeleteObject :exec
+DELETE FROM objects WHERE id = $1;
+
+-- name: LockContentHash :exec
+SELECT pg_advisory_xact_lock(hashtext(sqlc.arg(key)::text));
+
+-- name: ListObjects :many
+SELECT o.id, o.object_key, o.sha256, o.size, o.status, COUNT(f.id) AS file_count
+FROM objects o
+LEFT JOIN files f ON f.object_id = o.id
+GROUP BY o.id
+ORDER BY o.id;
+
+-- name: UpdateObjectStatus :exec
+UPDATE objects SET status = $1 WHERE id = $2;
+
+-- name: InsertAdoptedFile :execrows
+INSERT INTO files (owner_id, name, extension, mime_type, size, category_id, object_id, created_at, uploaded_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $1)
+ON CONFLICT (owner_id, folder, name) DO NOTHING;
+
+-- name: ListCategories :many
+SELECT c.name FROM categories c
+WHERE c.owner_id = $1 AND EXISTS (SELECT 1 FROM files f WHERE f.category_id = c.id AND f.object_id IS NOT NULL)
+ORDER BY c.name;
 
 -- name: ListFilesInCategory :many
-SELECT file_name FROM line_01 WHERE theme = $1;
+SELECT f.name,
+       ARRAY(SELECT t.name FROM file_tags ft JOIN tags t ON t.id = ft.tag_id
+             WHERE ft.file_id = f.id ORDER BY t.name)::text[] AS tags
+FROM files f
+JOIN categories c ON c.id = f.category_id
+WHERE f.owner_id = sqlc.arg(owner_id) AND c.name = sqlc.arg(category) AND f.object_id IS NOT NULL
+ORDER BY f.name;
+
+-- name: ListFilesWithTags :many
+SELECT f.name,
+       ARRAY(SELECT t.name FROM file_tags ft JOIN tags t ON t.id = ft.tag_id
+             WHERE ft.file_id = f.id ORDER BY t.name)::text[] AS tags
+FROM files f
+WHERE f.owner_id = sqlc.arg(owner_id) AND f.object_id IS NOT NULL
+  AND (SELECT COUNT(*) FROM file_tags ft JOIN tags t ON t.id = ft.tag_id
+       WHERE ft.file_id = f.id AND t.name = ANY(sqlc.arg(tags)::text[])) = cardinality(sqlc.arg(tags)::text[])
+ORDER BY f.name;
 
--- name: RenameFile :exec
-UPDATE line_01 SET file_name = $1 WHERE file_name = $2;
+-- name: UpsertTag :one
+INSERT INTO tags (owner_id, name)
+VALUES ($1, $2)
+ON CONFLICT (owner_id, name) DO UPDATE SET name = EXCLUDED.name
+RETURNING id;
+
+-- name: AddFileTag :exec
+INSERT INTO file_tags (file_id, tag_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING;
+
+-- name: RemoveFileTag :execrows
+DELETE FROM file_tags ft
+USING tags t
+WHERE ft.tag_id = t.id AND ft.file_id = sqlc.arg(file_id) AND t.name = sqlc.arg(tag);
+
+-- name: RenameFile :execrows
+UPDATE files SET name = sqlc.arg(new_name), updated_at = now()
+WHERE owner_id = sqlc.arg(owner_id) AND folder = '/' AND name = sqlc.arg(old_name);
 
 -- name: DeleteFile :exec
-DELETE FROM line_01 WHERE file_name = $1;
+DELETE FROM files WHERE owner_id = $1 AND folder = '/' AND name = $2;
+
+-- name: CreateShare :exec
+INSERT INTO shares (id, file_id, user_id, expires_at)
+VALUES ($1, $2, $3, $4);
+
+-- name: RecordShareAccess :one
+UPDATE shares s
+SET access_count = s.access_count + 1, last_accessed_at = now()
+FROM files f
+JOIN objects o ON o.id = f.object_id
+WHERE s.id = $1 AND s.file_id = f.id AND s.revoked_at IS NULL AND s.expires_at > now()
+  AND o.status <> 'broken'
+RETURNING o.object_key, f.name, f.extension, f.mime_type;
+
+-- name: GetShare :one
+SELECT o.object_key, f.name, f.extension, f.mime_type
+FROM shares s
+JOIN files f ON f.id = s.file_id
+JOIN objects o ON o.id = f.object_id
+WHERE s.id = $1 AND s.revoked_at IS NULL AND s.expires_at > now() AND o.status <> 'broken';
+
+-- name: RevokeShares :many
+UPDATE shares
+SET revoked_at = now()
+WHERE revoked_at IS NULL AND expires_at > now()
+  AND file_id = (SELECT f.id FROM files f WHERE f.owner_id = $1 AND f.folder = '/' AND f.name = $2)
+RETURNING access_count;
+
+-- name: GetAutosave :one
+SELECT owner_id, enabled, category, updated_by, updated_at FROM autosave_settings WHERE owner_id = $1;
+
+-- name: UpsertAutosave :exec
+INSERT INTO autosave_settings (owner_id, enabled, category, updated_by, updated_at)
+VALUES ($1, $2, $3, $4, now())
+ON CONFLICT (owner_id) DO UPDATE
+SET enabled = EXCLUDED.enabled, category = EXCLUDED.category,
+    updated_by = EXCLUDED.updated_by, updated_at = now();
+
+-- name: GetSuspension :one
+SELECT user_id, reason, suspended_by, suspended_at FROM suspended_users WHERE user_id = $1;
+
+-- name: SuspendUser :exec
+INSERT INTO suspended_users (user_id, reason, suspended_by)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id) DO UPDATE
+SET reason = EXCLUDED.reason, suspended_by = EXCLUDED.suspended_by, suspended_at = now();
+
+-- name: UnsuspendUser :execrows
+DELETE FROM suspended_users WHERE user_id = $1;
+
+-- name: GetGlobalStats :one
+SELECT (SELECT COUNT(DISTINCT owner_id) FROM files) AS owners,
+       (SELECT COUNT(*) FROM files WHERE object_id IS NOT NULL) AS files,
+       (SELECT COUNT(*) FROM objects) AS objects,
+       (SELECT COALESCE(SUM(size), 0) FROM objects)::bigint AS stored_bytes,
+       (SELECT COUNT(*) FROM shares WHERE revoked_at IS NULL AND expires_at > now()) AS active_shares,
+       (SELECT COUNT(*) FROM suspended_users) AS suspended_users;
+
+-- name: ListOwnerFiles :many
+SELECT f.name, COALESCE(c.name, '') AS category, f.size
+FROM files f
+LEFT JOIN categories c ON c.id = f.category_id
+WHERE f.owner_id = $1 AND f.object_id IS NOT NULL
+ORDER BY c.name, f.name;
+
+-- name: InsertAuditLog :exec
+INSERT INTO audit_log (user_id, source_id, command, target, result, error)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: GetQuota :one
+SELECT owner_id, max_bytes, max_files, updated_by, updated_at FROM quotas WHERE owner_id = $1;
+
+-- name: SetQuota :exec
+INSERT INTO quotas (owner_id, max_bytes, max_files, updated_by, updated_at)
+VALUES ($1, $2, $3, $4, now())
+ON CONFLICT (owner_id) DO UPDATE
+SET max_bytes = EXCLUDED.max_bytes, max_files = EXCLUDED.max_files,
+    updated_by = EXCLUDED.updated_by, updated_at = now();
+
+-- name: DeleteQuota :execrows
+DELETE FROM quotas WHERE owner_id = $1;
+
+-- name: GetUsage :one
+SELECT bytes, files FROM storage_usage WHERE owner_id = $1;
+
+-- name: EnsureUsage :exec
+INSERT INTO storage_usage (owner_id) VALUES ($1)
+ON CONFLICT (owner_id) DO NOTHING;
+
+-- name: ReserveUsage :execrows
+UPDATE storage_usage
+SET bytes = bytes + sqlc.arg(bytes)::bigint, files = files + 1, updated_at = now()
+WHERE owner_id = sqlc.arg(owner_id)
+  AND (sqlc.arg(max_bytes)::bigint = 0 OR bytes + sqlc.arg(bytes)::bigint <= sqlc.arg(max_bytes)::bigint)
+  AND (sqlc.arg(max_files)::bigint = 0 OR files + 1 <= sqlc.arg(max_files)::bigint);
+
+-- name: ReleaseUsage :exec
+UPDATE storage_usage
+SET bytes = GREATEST(bytes - sqlc.arg(bytes)::bigint, 0), files = GREATEST(files - 1, 0), updated_at = now()
+WHERE owner_id = sqlc.arg(owner_id);
+
+-- name: ListAuditLog :many
+SELECT id, user_id, source_id, command, target, result, error, created_at FROM audit_log
+WHERE (sqlc.narg(user_id)::text IS NULL OR user_id = sqlc.narg(user_id))
+  AND created_at >= sqlc.arg(since)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: ExportAuditLog :many
+SELECT id, user_id, source_id, command, target, result, error, created_at FROM audit_log
+WHERE (sqlc.narg(user_id)::text IS NULL OR user_id = sqlc.narg(user_id))
+  AND created_at >= sqlc.arg(since)
+ORDER BY created_at, id;
+
+-- name: ClaimWebhookEvent :execrows
+INSERT INTO webhook_events (event_id) VALUES ($1)
+ON CONFLICT (event_id) DO UPDATE
+SET claimed_at = now(), attempts = webhook_events.attempts + 1
+WHERE webhook_events.completed_at IS NULL AND webhook_events.claimed_at < sqlc.arg(stale_before);
+
+-- name: CompleteWebhookEvent :exec
+UPDATE webhook_events SET completed_at = now() WHERE event_id = $1;
+
+-- name: PruneWebhookEvents :execrows
+DELETE FROM webhook_events WHERE claimed_at < $1;
+
+-- name: TakeRateLimitToken :one
+INSERT INTO rate_limit_buckets AS b (bucket_key, tokens, updated_at)
+VALUES (sqlc.arg(bucket_key), sqlc.arg(capacity)::float8 - 1, now())
+ON CONFLICT (bucket_key) DO UPDATE
+SET tokens = LEAST(sqlc.arg(capacity)::float8,
+        b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * sqlc.arg(refill_per_second)::float8) - 1,
+    updated_at = now()
+WHERE LEAST(sqlc.arg(capacity)::float8,
+        b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * sqlc.arg(refill_per_second)::float8) >= 1
+RETURNING tokens;
+
+-- name: PruneRateLimitBuckets :execrows
+DELETE FROM rate_limit_buckets WHERE updated_at < $1;
+
+-- name: GetUserLanguage :one
+SELECT language FROM user_languages WHERE user_id = $1;
+
+-- name: SetUserLanguage :exec
+INSERT INTO user_languages (user_id, language, updated_at)
+VALUES ($1, $2, now())
+ON CONFLICT (user_id) DO UPDATE
+SET language = EXCLUDED.language, updated_at = now();
+
+-- name: InitUserLanguage :exec
+INSERT INTO user_languages (user_id, language) VALUES ($1, $2)
+ON CONFLICT (user_id) DO NOTHING;
+
+-- name: SavePendingEvent :exec
+INSERT INTO pending_events (event_id, payload, redelivery) VALUES ($1, $2, $3);
+
+-- name: TakePendingEvents :many
+WITH taken AS (
+    DELETE FROM pending_events RETURNING id, event_id, payload, redelivery
+)
+SELECT event_id, payload, redelivery FROM taken ORDER BY id;
+
+-- name: SaveUploadSession :exec
+INSERT INTO upload_sessions (session_key, filename) VALUES ($1, $2)
+ON CONFLICT (session_key) DO UPDATE SET filename = EXCLUDED.filename, saved_at = now();
+
+-- name: TakeUploadSessions :many
+DELETE FROM upload_sessions RETURNING session_key, filename;
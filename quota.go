@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"Line01/db"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// โควต้าเริ่มต้นต่อ library (0 = ไม่จำกัด), แก้ได้ด้วย env หรือ admin quota
+var (
+	userQuota  = quotaLimits{Bytes: 1 << 30, Files: 1000} // QUOTA_USER_BYTES, QUOTA_USER_FILES
+	groupQuota = quotaLimits{Bytes: 5 << 30, Files: 5000} // QUOTA_GROUP_BYTES, QUOTA_GROUP_FILES
+)
+
+var errQuotaExceeded = errors.New("storage quota exceeded")
+
+// quotaLimits is the maximum size and number of files of a library; zero
+// means unlimited
+type quotaLimits struct {
+	Bytes int64
+	Files int64
+}
+
+// initQuotas loads the default quotas from the environment
+func initQuotas() {
+	loadQuotaEnv("QUOTA_USER_BYTES", &userQuota.Bytes, parseBytes)
+	loadQuotaEnv("QUOTA_USER_FILES", &userQuota.Files, parseCount)
+	loadQuotaEnv("QUOTA_GROUP_BYTES", &groupQuota.Bytes, parseBytes)
+	loadQuotaEnv("QUOTA_GROUP_FILES", &groupQuota.Files, parseCount)
+}
+
+// loadQuotaEnv overrides target with the parsed value of an environment variable, if set
+func loadQuotaEnv(name string, target *int64, parse func(string) (int64, error)) {
+	value := os.Getenv(name)
+	if value == "" {
+		return
+	}
+	n, err := parse(value)
+	if err != nil {
+		fatal("invalid "+name, err)
+	}
+	*target = n
+}
+
+// parseBytes parses a size such as 500MB, 2GB or a plain byte count
+func parseBytes(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if number, ok := strings.CutSuffix(s, unit.suffix); ok {
+			s, multiplier = strings.TrimSpace(number), unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// parseCount parses a non-negative file count
+func parseCount(s string) (int64, error) {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid count %q", s)
+	}
+	return n, nil
+}
+
+// isGroupOwner reports whether a library belongs to a group or room rather
+// than a user; LINE group IDs start with C and room IDs with R
+func isGroupOwner(ownerID string) bool {
+	return strings.HasPrefix(ownerID, "C") || strings.HasPrefix(ownerID, "R")
+}
+
+// quotaFor returns the limits of ownerID's library: the admin override if
+// one is set, otherwise the default for users or groups
+func quotaFor(ctx context.Context, q *db.Queries, ownerID string) (quotaLimits, error) {
+	limits := userQuota
+	if isGroupOwner(ownerID) {
+		limits = groupQuota
+	}
+
+	override, err := q.GetQuota(ctx, ownerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return limits, nil
+	}
+	if err != nil {
+		return limits, fmt.Errorf("failed to read quota: %w", err)
+	}
+	if override.MaxBytes.Valid {
+		limits.Bytes = override.MaxBytes.Int64
+	}
+	if override.MaxFiles.Valid {
+		limits.Files = override.MaxFiles.Int64
+	}
+	return limits, nil
+}
+
+// storageUsage returns how many bytes and files ownerID's library uses
+func storageUsage(ctx context.Context, ownerID string) (db.GetUsageRow, error) {
+	usage, err := queries.GetUsage(ctx, ownerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.GetUsageRow{}, nil
+	}
+	return usage, err
+}
+
+// checkQuota reports errQuotaExceeded if adding one file of size bytes would
+// exceed ownerID's quota. It is an early check only; reserveQuota enforces
+// the quota when the file is stored.
+func checkQuota(ctx context.Context, ownerID string, size int64) error {
+	limits, err := quotaFor(ctx, queries, ownerID)
+	if err != nil {
+		return err
+	}
+	usage, err := storageUsage(ctx, ownerID)
+	if err != nil {
+		return fmt.Errorf("failed to read usage: %w", err)
+	}
+	if limits.Bytes > 0 && usage.Bytes+size > limits.Bytes {
+		return errQuotaExceeded
+	}
+	if limits.Files > 0 && usage.Files+1 > limits.Files {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// reserveQuota adds one file of size bytes to ownerID's usage within the
+// transaction of qtx, or returns errQuotaExceeded if that would exceed the
+// quota. The usage row stays locked until the transaction ends, so
+// concurrent uploads to the same library cannot overshoot; keep slow work
+// such as the R2 upload out of that transaction.
+func reserveQuota(ctx context.Context, qtx *db.Queries, ownerID string, size int64) error {
+	limits, err := quotaFor(ctx, qtx, ownerID)
+	if err != nil {
+		return err
+	}
+	if err := qtx.EnsureUsage(ctx, ownerID); err != nil {
+		return fmt.Errorf("failed to create usage: %w", err)
+	}
+	n, err := qtx.ReserveUsage(ctx, db.ReserveUsageParams{
+		Bytes:    size,
+		OwnerID:  ownerID,
+		MaxBytes: limits.Bytes,
+		MaxFiles: limits.Files,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update usage: %w", err)
+	}
+	if n == 0 {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// quotaExceededReply explains a rejected upload
+func quotaExceededReply(ctx context.Context, ownerID string) string {
+	if isGroupOwner(ownerID) {
+		return t(ctx, msgQuotaFullGroup)
+	}
+	return t(ctx, msgQuotaFullUser)
+}
+
+// handleQuotaCommand replies with the usage of the library the command was
+// sent from
+func handleQuotaCommand(ctx context.Context, event *linebot.Event, entry *auditEntry) {
+	ownerID := sourceOwnerID(event.Source)
+	title := t(ctx, msgYourStorage)
+	if isGroupOwner(ownerID) {
+		title = t(ctx, msgGroupStorage)
+	}
+
+	message, err := quotaMessage(ctx, ownerID, title)
+	if err != nil {
+		entry.Err = err
+		slog.ErrorContext(ctx, "error reading quota", "owner_id", ownerID, "error", err)
+		reply(ctx, event, linebot.NewTextMessage(errorReply(ctx, t(ctx, msgErrReadUsage), err)))
+		return
+	}
+	reply(ctx, event, message)
+}
+
+// quotaMessage renders the usage and limits of ownerID's library
+func quotaMessage(ctx context.Context, ownerID, title string) (*linebot.FlexMessage, error) {
+	limits, err := quotaFor(ctx, queries, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := storageUsage(ctx, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage: %w", err)
+	}
+
+	space := usageBar{Label: t(ctx, msgSpace), Used: formatBytes(usage.Bytes), Limit: t(ctx, msgUnlimited), Ratio: -1}
+	if limits.Bytes > 0 {
+		space.Limit = formatBytes(limits.Bytes)
+		space.Ratio = float64(usage.Bytes) / float64(limits.Bytes)
+	}
+	files := usageBar{Label: t(ctx, msgFiles), Used: strconv.FormatInt(usage.Files, 10), Limit: t(ctx, msgUnlimited), Ratio: -1}
+	if limits.Files > 0 {
+		files.Limit = strconv.FormatInt(limits.Files, 10)
+		files.Ratio = float64(usage.Files) / float64(limits.Files)
+	}
+	return quotaFlex(ctx, title, []usageBar{space, files}), nil
+}
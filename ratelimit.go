@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"Line01/db"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// ประเภทคำสั่งสำหรับ rate limit
+const (
+	rateClassCommand = "command" // list, open, tag, rename, delete, ...
+	rateClassUpload  = "upload"  // upload and the files sent after it
+	rateClassShare   = "share"   // share and unshare
+)
+
+// rateBucketRetention is how long an idle bucket is kept; a bucket that has
+// been idle longer than its refill time is full anyway
+const rateBucketRetention = 24 * time.Hour
+
+var errRateLimited = errors.New("rate limited")
+
+// rateLimit is a token bucket: up to Burst commands at once, refilled at
+// Burst per Per. A zero Burst disables the limit.
+type rateLimit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// ขีดจำกัดต่อผู้ใช้และต่อกลุ่ม แก้ได้ด้วย RATE_LIMIT_USER_<CLASS> และ
+// RATE_LIMIT_GROUP_<CLASS> เช่น RATE_LIMIT_USER_UPLOAD=10/1m, 0 = ไม่จำกัด
+var (
+	userRateLimits = map[string]rateLimit{
+		rateClassCommand: {Burst: 30, Per: time.Minute},
+		rateClassUpload:  {Burst: 10, Per: time.Minute},
+		rateClassShare:   {Burst: 10, Per: time.Hour},
+	}
+	groupRateLimits = map[string]rateLimit{
+		rateClassCommand: {Burst: 60, Per: time.Minute},
+		rateClassUpload:  {Burst: 30, Per: time.Minute},
+		rateClassShare:   {Burst: 30, Per: time.Hour},
+	}
+)
+
+// initRateLimits loads the rate limits from the environment and drops idle
+// buckets
+func initRateLimits() {
+	for class := range userRateLimits {
+		loadRateLimitEnv("RATE_LIMIT_USER_"+strings.ToUpper(class), userRateLimits, class)
+		loadRateLimitEnv("RATE_LIMIT_GROUP_"+strings.ToUpper(class), groupRateLimits, class)
+	}
+
+	n, err := queries.PruneRateLimitBuckets(context.Background(), time.Now().Add(-rateBucketRetention))
+	if err != nil {
+		slog.Error("error pruning rate limit buckets", "error", err)
+		return
+	}
+	slog.Debug("pruned rate limit buckets", "count", n)
+}
+
+// loadRateLimitEnv overrides limits[class] with an environment variable such
+// as "10/1m", if set
+func loadRateLimitEnv(name string, limits map[string]rateLimit, class string) {
+	value := os.Getenv(name)
+	if value == "" {
+		return
+	}
+	limit, err := parseRateLimit(value)
+	if err != nil {
+		fatal("invalid "+name, err)
+	}
+	limits[class] = limit
+}
+
+// parseRateLimit parses "<burst>/<duration>", e.g. 10/1m, or 0 for no limit
+func parseRateLimit(s string) (rateLimit, error) {
+	s = strings.TrimSpace(s)
+	if s == "0" {
+		return rateLimit{}, nil
+	}
+	burst, per, ok := strings.Cut(s, "/")
+	n, err := strconv.Atoi(burst)
+	if !ok || err != nil || n < 0 {
+		return rateLimit{}, fmt.Errorf("want <count>/<duration> such as 10/1m, got %q", s)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return rateLimit{}, fmt.Errorf("want <count>/<duration> such as 10/1m, got %q", s)
+	}
+	return rateLimit{Burst: n, Per: d}, nil
+}
+
+// rateClass returns the rate limit class of a text command
+func rateClass(command string) string {
+	switch command {
+	case "upload":
+		return rateClassUpload
+	case "share", "unshare":
+		return rateClassShare
+	}
+	return rateClassCommand
+}
+
+// allowCommand takes a token from the sender's bucket for class and, in
+// groups and rooms, from the chat's bucket too. Admins are never limited,
+// and commands are allowed if the buckets cannot be read.
+func allowCommand(ctx context.Context, source *linebot.EventSource, class string) bool {
+	if isAdmin(source.UserID) {
+		return true
+	}
+	if source.UserID != "" && !takeToken(ctx, "user:"+source.UserID+":"+class, userRateLimits[class]) {
+		return false
+	}
+	if isGroupSource(source) && !takeToken(ctx, "chat:"+sourceOwnerID(source)+":"+class, groupRateLimits[class]) {
+		return false
+	}
+	return true
+}
+
+// takeToken takes one token from the bucket key and reports whether there
+// was one. Buckets live in Postgres so every replica shares them.
+func takeToken(ctx context.Context, key string, limit rateLimit) bool {
+	if limit.Burst <= 0 {
+		return true
+	}
+	_, err := queries.TakeRateLimitToken(ctx, db.TakeRateLimitTokenParams{
+		BucketKey:       key,
+		Capacity:        float64(limit.Burst),
+		RefillPerSecond: float64(limit.Burst) / limit.Per.Seconds(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		slog.InfoContext(ctx, "rate limited", "bucket", key)
+		return false
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "error reading rate limit, allowing", "bucket", key, "error", err)
+	}
+	return true
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"Line01/db"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// สถานะของ object ใน objects.status
+const (
+	objectStatusOK     = "ok"
+	objectStatusBroken = "broken"
+)
+
+// runReconcile compares the objects in the bucket with the objects table and
+// reports (or, with -apply, fixes) any inconsistencies:
+//   - orphan objects in the bucket that no object row references
+//   - object rows that no file references
+//   - object rows whose object is missing from the bucket
+//   - object rows whose recorded size or SHA-256 does not match the object
+//
+// Usage: reconcile [-apply] [-orphans report|adopt|delete] [-adopt-user ID] [-verify-hash]
+func runReconcile(args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	apply := flags.Bool("apply", false, "apply fixes (default is a dry run)")
+	orphans := flags.String("orphans", "report", "action for orphan objects: report, adopt or delete")
+	adoptUser := flags.String("adopt-user", "", "LINE user ID that owns adopted objects")
+	verifyHash := flags.Bool("verify-hash", false, "download objects and verify their SHA-256")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	switch *orphans {
+	case "report", "delete":
+	case "adopt":
+		if *adoptUser == "" {
+			return fmt.Errorf("-orphans adopt requires -adopt-user")
+		}
+	default:
+		return fmt.Errorf("unknown -orphans action %q", *orphans)
+	}
+
+	ctx := context.Background()
+
+	objects, err := listR2Objects(ctx, s3Client, bucket)
+	if err != nil {
+		return err
+	}
+	rows, err := queries.ListObjects(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing objects from DB: %w", err)
+	}
+
+	mode := "dry run"
+	if *apply {
+		mode = "applying fixes"
+	}
+	fmt.Printf("Reconciling bucket %s (%s): %d stored objects, %d object rows\n", bucket, mode, len(objects), len(rows))
+
+	stored := make(map[string]types.Object, len(objects))
+	for _, obj := range objects {
+		stored[aws.ToString(obj.Key)] = obj
+	}
+
+	var missing, mismatched, orphaned, fixed int
+	referenced := make(map[string]bool)
+
+	for _, row := range rows {
+		key := row.ObjectKey
+		referenced[strings.TrimSuffix(key, filepath.Ext(key))] = true
+
+		if row.FileCount == 0 {
+			fmt.Printf("UNUSED    object %d %s: no file references it\n", row.ID, key)
+			orphaned++
+			if *orphans == "delete" {
+				fmt.Printf("          -> delete object %d\n", row.ID)
+				if *apply {
+					if err := queries.DeleteObject(ctx, row.ID); err != nil {
+						return fmt.Errorf("error deleting object %d: %w", row.ID, err)
+					}
+					if _, ok := stored[key]; ok {
+						if err := deleteFromR2(ctx, key); err != nil {
+							return err
+						}
+					}
+					fixed++
+				}
+				continue
+			}
+		}
+
+		status := objectStatusOK
+		obj, ok := stored[key]
+		switch {
+		case !ok:
+			fmt.Printf("MISSING   object %d %s (%d files): not found in bucket\n", row.ID, key, row.FileCount)
+			missing++
+			status = objectStatusBroken
+		case row.Size.Valid && row.Size.Int64 != aws.ToInt64(obj.Size):
+			fmt.Printf("SIZE      object %d %s (%d files): recorded %d bytes, bucket has %d\n",
+				row.ID, key, row.FileCount, row.Size.Int64, aws.ToInt64(obj.Size))
+			mismatched++
+			status = objectStatusBroken
+		case *verifyHash && row.Sha256.Valid:
+			sum, err := hashR2Object(ctx, key)
+			if err != nil {
+				fmt.Printf("ERROR     object %d %s: %v\n", row.ID, key, err)
+				continue
+			}
+			if sum != row.Sha256.String {
+				fmt.Printf("HASH      object %d %s (%d files): recorded %s, bucket has %s\n",
+					row.ID, key, row.FileCount, row.Sha256.String, sum)
+				mismatched++
+				status = objectStatusBroken
+			}
+		}
+
+		if status == row.Status {
+			continue
+		}
+		fmt.Printf("          -> mark object %d as %s\n", row.ID, status)
+		if *apply {
+			err := queries.UpdateObjectStatus(ctx, db.UpdateObjectStatusParams{Status: status, ID: row.ID})
+			if err != nil {
+				return fmt.Errorf("error updating status of object %d: %w", row.ID, err)
+			}
+			fixed++
+		}
+	}
+
+	for _, obj := range objects {
+		key := aws.ToString(obj.Key)
+		if isReferencedKey(key, referenced) {
+			continue
+		}
+
+		rendition := strings.HasPrefix(key, renditionPrefix)
+		if rendition {
+			fmt.Printf("ORPHAN    rendition %s (%d bytes)\n", key, aws.ToInt64(obj.Size))
+		} else {
+			fmt.Printf("ORPHAN    object %s (%d bytes)\n", key, aws.ToInt64(obj.Size))
+		}
+		orphaned++
+
+		switch {
+		case *orphans == "delete" || (*orphans == "adopt" && rendition):
+			fmt.Printf("          -> delete %s\n", key)
+			if *apply {
+				if err := deleteFromR2(ctx, key); err != nil {
+					return err
+				}
+				fixed++
+			}
+		case *orphans == "adopt":
+			name := strings.TrimSuffix(key, filepath.Ext(key))
+			fmt.Printf("          -> adopt as %q for user %s\n", name, *adoptUser)
+			if *apply {
+				if err := adoptObject(ctx, *adoptUser, name, obj); err != nil {
+					return err
+				}
+				fixed++
+			}
+		}
+	}
+
+	fmt.Printf("Done: %d missing, %d mismatched, %d orphaned, %d fixed\n", missing, mismatched, orphaned, fixed)
+	if !*apply && missing+mismatched+orphaned > 0 {
+		fmt.Println("Dry run only; re-run with -apply to fix.")
+	}
+	return nil
+}
+
+// isReferencedKey reports whether key is a referenced object or a rendition of one
+func isReferencedKey(key string, referenced map[string]bool) bool {
+	if base, ok := strings.CutPrefix(key, renditionPrefix); ok {
+		base = strings.TrimSuffix(base, ".jpg")
+		base = strings.TrimSuffix(base, ".preview")
+		return referenced[base]
+	}
+	return referenced[strings.TrimSuffix(key, filepath.Ext(key))]
+}
+
+// adoptObject records an orphan object as a file owned by userID in the
+// "recovered" category
+func adoptObject(ctx context.Context, userID, name string, obj types.Object) error {
+	key := aws.ToString(obj.Key)
+	sum, err := hashR2Object(ctx, key)
+	if err != nil {
+		return err
+	}
+	hash := sql.NullString{String: sum, Valid: true}
+	size := sql.NullInt64{Int64: aws.ToInt64(obj.Size), Valid: true}
+
+	// เนื้อหาเดียวกันมี object อื่นเก็บไว้แล้ว: รับไว้โดยไม่บันทึก hash ซ้ำ
+	if _, err := queries.GetObjectByHash(ctx, hash); err == nil {
+		hash.Valid = false
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("error looking up hash of %s: %w", key, err)
+	}
+
+	tx, err := dbconn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	qtx := instrumentDB(tx)
+
+	objectID, err := qtx.InsertObject(ctx, db.InsertObjectParams{
+		ObjectKey: key,
+		Sha256:    hash,
+		Size:      size,
+	})
+	if err != nil {
+		return fmt.Errorf("error adopting object %s: %w", key, err)
+	}
+
+	categoryID, err := qtx.UpsertCategory(ctx, db.UpsertCategoryParams{OwnerID: userID, Name: "recovered"})
+	if err != nil {
+		return fmt.Errorf("error creating category: %w", err)
+	}
+
+	created, err := qtx.InsertAdoptedFile(ctx, db.InsertAdoptedFileParams{
+		OwnerID:    userID,
+		Name:       name,
+		Extension:  filepath.Ext(key),
+		Size:       size,
+		CategoryID: sql.NullInt64{Int64: categoryID, Valid: true},
+		ObjectID:   sql.NullInt64{Int64: objectID, Valid: true},
+		CreatedAt:  aws.ToTime(obj.LastModified),
+	})
+	if err != nil {
+		return fmt.Errorf("error adopting object %s: %w", key, err)
+	}
+	if created == 0 {
+		fmt.Printf("          file %q already exists for user %s; object %s kept without a file\n", name, userID, key)
+		return tx.Commit()
+	}
+
+	// 📦 Count the adopted file in the owner's usage. The object is already
+	// stored, so it is counted without limits even when the quota is full.
+	if err := qtx.EnsureUsage(ctx, userID); err != nil {
+		return fmt.Errorf("failed to create usage: %w", err)
+	}
+	_, err = qtx.ReserveUsage(ctx, db.ReserveUsageParams{Bytes: size.Int64, OwnerID: userID})
+	if err != nil {
+		return fmt.Errorf("failed to update usage: %w", err)
+	}
+	return tx.Commit()
+}
+
+// hashR2Object streams an object and returns its hex SHA-256
+func hashR2Object(ctx context.Context, key string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	defer result.Body.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, result.Body); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
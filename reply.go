@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// reply answers an event. Reply tokens expire about a minute after the
+// event, which a queued event or a large upload can outlast, so when LINE
+// rejects the token the messages are pushed to the chat instead. Failures
+// are logged and counted; handlers carry on either way.
+func reply(ctx context.Context, event *linebot.Event, messages ...linebot.SendingMessage) {
+	// The reply still goes out if the event itself has run out of time
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), replyTimeout)
+	defer cancel()
+
+	if event.ReplyToken != "" {
+		_, err := bot.ReplyMessage(event.ReplyToken, messages...).WithContext(ctx).Do()
+		repliesTotal.WithLabelValues("reply", metricResult(err)).Inc()
+		if err == nil {
+			return
+		}
+		if !isInvalidReplyToken(err) {
+			slog.ErrorContext(ctx, "error sending reply", "error", err)
+			return
+		}
+		slog.InfoContext(ctx, "reply token expired, pushing instead")
+	}
+
+	to := sourceOwnerID(event.Source)
+	if to == "" {
+		slog.WarnContext(ctx, "no reply token and no chat to push to")
+		return
+	}
+	_, err := bot.PushMessage(to, messages...).WithContext(ctx).Do()
+	repliesTotal.WithLabelValues("push", metricResult(err)).Inc()
+	if err != nil {
+		slog.ErrorContext(ctx, "error pushing message", "to", to, "error", err)
+	}
+}
+
+// isInvalidReplyToken reports whether LINE rejected a reply because its token
+// was expired, already used or otherwise invalid
+func isInvalidReplyToken(err error) bool {
+	var apiErr *linebot.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusBadRequest || apiErr.Response == nil {
+		return false
+	}
+	return strings.Contains(strings.ToLower(apiErr.Response.Message), "reply token")
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	crand "crypto/rand"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/lib/pq"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// retryPolicy retries a failed call with exponential backoff and jitter.
+// Only errors that are known to be transient are retried.
+type retryPolicy struct {
+	Dependency string        // metric label: line, postgres or r2
+	Attempts   int           // tries in total, including the first
+	Base       time.Duration // delay before the first retry, doubled after each one
+	Max        time.Duration // cap on a single delay
+}
+
+// นโยบาย retry ของแต่ละ dependency
+var (
+	lineRetry     = retryPolicy{Dependency: "line", Attempts: 4, Base: 500 * time.Millisecond, Max: 10 * time.Second}
+	postgresRetry = retryPolicy{Dependency: "postgres", Attempts: 3, Base: 50 * time.Millisecond, Max: time.Second}
+	r2Retry       = retryPolicy{Dependency: "r2", Attempts: 4, Base: 200 * time.Millisecond, Max: 5 * time.Second}
+)
+
+// delay returns how long to wait before retry number attempt (1 for the
+// first retry): half of the exponential delay plus a random share of the
+// other half, so that callers failing together do not retry together
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.Base << (attempt - 1)
+	if d <= 0 || d > p.Max {
+		d = p.Max
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// wait sleeps before retry number attempt, or at least min when the server
+// asked for it, and counts the retry. It returns early if ctx is done.
+func (p retryPolicy) wait(ctx context.Context, attempt int, min time.Duration) error {
+	retriesTotal.WithLabelValues(p.Dependency).Inc()
+	d := max(p.delay(attempt), min)
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// do calls fn until it succeeds, fails with an error retryable rejects, or
+// the attempts run out
+func (p retryPolicy) do(ctx context.Context, retryable func(error) bool, fn func() error) error {
+	err := fn()
+	for attempt := 1; attempt < p.Attempts && err != nil && retryable(err); attempt++ {
+		if waitErr := p.wait(ctx, attempt, 0); waitErr != nil {
+			return err
+		}
+		err = fn()
+	}
+	return err
+}
+
+// BackoffDelay lets the S3 client's retryer use the policy's delays
+func (p retryPolicy) BackoffDelay(attempt int, err error) (time.Duration, error) {
+	retriesTotal.WithLabelValues(p.Dependency).Inc()
+	return p.delay(attempt), nil
+}
+
+// r2Retryer configures the S3 client's standard retryer, which already knows
+// which S3 errors are transient, with the R2 policy
+func r2Retryer() aws.Retryer {
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxAttempts = r2Retry.Attempts
+		o.MaxBackoff = r2Retry.Max
+		o.Backoff = r2Retry
+	})
+}
+
+// isRetryableDBError reports whether a Postgres statement failed without
+// taking effect and is worth running again: the connection could not be made,
+// the server is starting up or out of connections, or the statement lost a
+// serialization conflict or deadlock and was rolled back
+func isRetryableDBError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "40001", "40P01", "53300", "57P03":
+			return true
+		}
+		return pqErr.Code.Class() == "08"
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// lineRetryTransport retries LINE API requests that failed with a network
+// error, 429 Too Many Requests or a 5xx status.
+//
+// Push and broadcast requests get an X-Line-Retry-Key so LINE can tell a
+// retry from a new message; a 409 on a retry means an earlier attempt was
+// accepted. Other POST requests, replies included, are retried only when
+// LINE rejected them with 429 and so did not act on them.
+type lineRetryTransport struct {
+	next http.RoundTripper
+}
+
+func (t lineRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	idempotent := req.Method == http.MethodGet || req.Method == http.MethodHead
+	if req.Method == http.MethodPost && isLinePushPath(req.URL.Path) {
+		if req.Header.Get("X-Line-Retry-Key") == "" {
+			req = req.Clone(req.Context())
+			req.Header.Set("X-Line-Retry-Key", newRetryKey())
+		}
+		idempotent = true
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return t.next.RoundTrip(req) // the body cannot be sent twice
+	}
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		resp, err := t.next.RoundTrip(req)
+		if attempt >= lineRetry.Attempts {
+			return resp, err
+		}
+
+		var min time.Duration
+		switch {
+		case err != nil:
+			if !idempotent || req.Context().Err() != nil {
+				return resp, err
+			}
+		case resp.StatusCode == http.StatusTooManyRequests:
+			min = retryAfter(resp)
+		case resp.StatusCode >= 500 && idempotent:
+		case resp.StatusCode == http.StatusConflict && attempt > 1 && req.Header.Get("X-Line-Retry-Key") != "":
+			resp.StatusCode, resp.Status = http.StatusOK, "200 OK"
+			return resp, nil
+		default:
+			return resp, nil
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if waitErr := lineRetry.wait(req.Context(), attempt, min); waitErr != nil {
+			return nil, waitErr
+		}
+	}
+}
+
+// isLinePushPath reports whether a path sends messages without a reply token
+func isLinePushPath(path string) bool {
+	for _, suffix := range []string{"/message/push", "/message/multicast", "/message/broadcast", "/message/narrowcast"} {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// newRetryKey returns a random UUID (version 4) for X-Line-Retry-Key
+func newRetryKey() string {
+	var b [16]byte
+	crand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// retryAfter returns the delay a 429 response asked for, if any
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// หมวดของ error ที่บอกผู้ใช้ได้
+const (
+	errorCategoryTemporary = "temporary" // a service was unreachable or timed out
+	errorCategoryThrottled = "throttled" // a service is rate limiting the bot
+	errorCategoryInternal  = "internal"  // retrying will not help
+)
+
+// errorCategory classifies an error that reached a handler after any retries
+func errorCategory(err error) string {
+	var apiErr *linebot.APIError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests:
+		return errorCategoryThrottled
+	case retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary:
+		return errorCategoryThrottled
+	case errors.As(err, &apiErr) && apiErr.Code >= 500:
+		return errorCategoryTemporary
+	case errors.Is(err, context.DeadlineExceeded), isRetryableDBError(err):
+		return errorCategoryTemporary
+	case retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary:
+		return errorCategoryTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return errorCategoryTemporary
+	}
+	return errorCategoryInternal
+}
+
+// errorHint is the sentence added to an error reply for each category
+var errorHint = map[string]msgKey{
+	errorCategoryTemporary: msgHintTemporary,
+	errorCategoryThrottled: msgHintThrottled,
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ค่า timeout ของ HTTP server; SHUTDOWN_TIMEOUT ปรับเวลารองานที่ค้างตอนปิด
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 30 * time.Second
+	writeTimeout      = 30 * time.Second
+	idleTimeout       = 2 * time.Minute
+
+	defaultShutdownTimeout = 30 * time.Second
+	shutdownGrace          = 5 * time.Second // after cancelling events that outlived the timeout
+)
+
+// serve runs the HTTP server until SIGTERM or SIGINT, then shuts down
+// gracefully: it stops accepting connections, waits for the event workers to
+// drain their queues and closes the database. Events still queued when the
+// wait runs out and uploads waiting for their file are saved for the next
+// start (see pending.go).
+func serve(port string) {
+	timeout := envDuration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout)
+	srv := &http.Server{
+		Addr:              ":" + port,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("server is running", "port", port)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		fatal("server stopped", err)
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process immediately
+
+	slog.Info("shutting down", "timeout", timeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Stop accepting webhooks first, then let the workers finish the events
+	// that were already acknowledged
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("error stopping HTTP server", "error", err)
+	}
+	if err := <-serverErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("server error during shutdown", "error", err)
+	}
+	if err := eventWorkers.close(shutdownCtx); err != nil {
+		// Cancel what is still running and give it a moment to wind down
+		slog.Error("shutdown timed out, cancelling in-flight events", "error", err)
+		cancelEvents()
+		graceCtx, cancelGrace := context.WithTimeout(context.Background(), shutdownGrace)
+		defer cancelGrace()
+		if err := eventWorkers.close(graceCtx); err != nil {
+			slog.Error("events did not stop in time", "error", err)
+			eventWorkers.abandon()
+		}
+	}
+	saveUploadSessions(context.Background())
+
+	if err := dbconn.Close(); err != nil {
+		slog.Error("error closing database", "error", err)
+	}
+	slog.Info("shutdown complete")
+}
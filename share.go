@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"Line01/db"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	defaultShareDuration = 24 * time.Hour
+	maxShareDuration     = 30 * 24 * time.Hour
+	sharePath            = "/s/"
+	shareWriteTimeout    = time.Hour // streaming one shared file to the client
+)
+
+var errSharingDisabled = errors.New("sharing is not configured")
+
+// shareSecret signs share tokens; SHARE_SECRET falls back to the channel secret
+func shareSecret() []byte {
+	if secret := os.Getenv("SHARE_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return []byte(os.Getenv("LINE_CHANNEL_SECRET"))
+}
+
+// parseShareDuration accepts Go durations ("90m", "12h") plus a day suffix ("7d")
+func parseShareDuration(s string) (time.Duration, error) {
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		d, err = time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+	}
+	if d <= 0 || d > maxShareDuration {
+		return 0, errors.New("duration must be positive and at most 30d")
+	}
+	return d, nil
+}
+
+// signShare returns the token <id>.<expiry>.<signature> for a share
+func signShare(id string, expiresAt time.Time) string {
+	payload := id + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	mac := hmac.New(sha256.New, shareSecret())
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyShareToken checks the signature and expiry and returns the share ID
+func verifyShareToken(token string) (string, bool) {
+	id, rest, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", false
+	}
+	expiry, _, ok := strings.Cut(rest, ".")
+	if !ok {
+		return "", false
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() >= unix {
+		return "", false
+	}
+	expected := signShare(id, time.Unix(unix, 0))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(token)) != 1 {
+		return "", false
+	}
+	return id, true
+}
+
+// createShareLink creates an expiring link to a file in ownerID's library on
+// behalf of userID
+func createShareLink(ctx context.Context, ownerID, userID, filename string, duration time.Duration) (string, time.Time, error) {
+	baseURL := strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if baseURL == "" {
+		return "", time.Time{}, errSharingDisabled
+	}
+
+	file, err := queries.GetFileObject(ctx, db.GetFileObjectParams{OwnerID: ownerID, Name: filename})
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !file.ObjectKey.Valid) {
+		return "", time.Time{}, errFileNotFound
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if file.Status.String == objectStatusBroken {
+		return "", time.Time{}, errFileBroken
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	id := base64.RawURLEncoding.EncodeToString(raw)
+	expiresAt := time.Now().Add(duration).Truncate(time.Second)
+
+	err = queries.CreateShare(ctx, db.CreateShareParams{
+		ID:        id,
+		FileID:    file.ID,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to save share: %w", err)
+	}
+
+	return baseURL + sharePath + signShare(id, expiresAt), expiresAt, nil
+}
+
+// revokeShareLinks revokes every active link of a file in ownerID's library. It returns the
+// number of links revoked and how many times they were accessed in total.
+func revokeShareLinks(ctx context.Context, ownerID, filename string) (int, int64, error) {
+	counts, err := queries.RevokeShares(ctx, db.RevokeSharesParams{
+		OwnerID: ownerID,
+		Name:    filename,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var accesses int64
+	for _, n := range counts {
+		accesses += n
+	}
+	return len(counts), accesses, nil
+}
+
+// lookupShare returns the file behind an active share. Only downloads are
+// counted; HEAD requests such as link checks just look the share up.
+func lookupShare(ctx context.Context, id string, download bool) (db.RecordShareAccessRow, error) {
+	if download {
+		return queries.RecordShareAccess(ctx, id)
+	}
+	share, err := queries.GetShare(ctx, id)
+	return db.RecordShareAccessRow(share), err
+}
+
+// shareHandler serves a shared file after checking its signed token
+func shareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, ok := verifyShareToken(strings.TrimPrefix(r.URL.Path, sharePath))
+	if !ok {
+		http.Error(w, "This link is invalid or has expired.", http.StatusNotFound)
+		return
+	}
+
+	share, err := lookupShare(r.Context(), id, r.Method == http.MethodGet)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "This link is invalid or has expired.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error looking up share", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	object, err := s3Client.GetObject(r.Context(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(share.ObjectKey),
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error fetching shared object", "object", share.ObjectKey, "error", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer object.Body.Close()
+
+	contentType := share.MimeType.String
+	if contentType == "" {
+		contentType = aws.ToString(object.ContentType)
+	}
+	w.Header().Set("Content-Type", contentType)
+	if object.ContentLength != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(*object.ContentLength, 10))
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{
+		"filename": share.Name + share.Extension,
+	}))
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	if r.Method == http.MethodHead {
+		return
+	}
+	// A large file on a slow connection takes longer than the server's
+	// WriteTimeout, so downloads get a deadline of their own
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(shareWriteTimeout)); err != nil {
+		slog.WarnContext(r.Context(), "could not clear write deadline", "error", err)
+	}
+	if _, err := io.Copy(w, object.Body); err != nil {
+		slog.WarnContext(r.Context(), "error streaming shared object", "object", share.ObjectKey, "error", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyShareToken(t *testing.T) {
+	t.Setenv("SHARE_SECRET", "test-secret")
+
+	future := time.Now().Add(time.Hour)
+	valid := signShare("abc123", future)
+	id, expiry, sig := splitToken(t, valid)
+
+	tests := []struct {
+		name   string
+		token  string
+		wantID string
+		wantOK bool
+	}{
+		{"valid", valid, "abc123", true},
+		{"expired", signShare("abc123", time.Now().Add(-time.Minute)), "", false},
+		{"tampered id", "abd123." + expiry + "." + sig, "", false},
+		{"extended expiry", id + "." + strconv.FormatInt(future.Unix()+3600, 10) + "." + sig, "", false},
+		{"tampered signature", id + "." + expiry + "." + strings.Repeat("A", len(sig)), "", false},
+		{"missing signature", id + "." + expiry, "", false},
+		{"empty signature", id + "." + expiry + ".", "", false},
+		{"trailing data", valid + ".x", "", false},
+		{"no separators", "abc123", "", false},
+		{"non-numeric expiry", id + ".soon." + sig, "", false},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotID, ok := verifyShareToken(tt.token)
+			if ok != tt.wantOK || gotID != tt.wantID {
+				t.Errorf("verifyShareToken(%q) = %q, %v; want %q, %v", tt.token, gotID, ok, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestVerifyShareTokenOtherSecret(t *testing.T) {
+	t.Setenv("SHARE_SECRET", "old-secret")
+	token := signShare("abc123", time.Now().Add(time.Hour))
+
+	t.Setenv("SHARE_SECRET", "new-secret")
+	if id, ok := verifyShareToken(token); ok {
+		t.Errorf("token signed with another secret was accepted as %q", id)
+	}
+}
+
+// splitToken returns the id, expiry and signature parts of a share token
+func splitToken(t *testing.T, token string) (string, string, string) {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token %q has %d parts, want 3", token, len(parts))
+	}
+	return parts[0], parts[1], parts[2]
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"Line01/db"
+)
+
+// normalizeTags strips leading '#', lower-cases and de-duplicates tag names.
+// Arguments that are only '#' are dropped, so the result may be empty.
+func normalizeTags(args []string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		tag := strings.ToLower(strings.TrimLeft(arg, "#"))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// tagFile adds tags to a file in ownerID's library
+func tagFile(ctx context.Context, ownerID, filename string, tags []string) error {
+	file, err := queries.GetFileObject(ctx, db.GetFileObjectParams{OwnerID: ownerID, Name: filename})
+	if errors.Is(err, sql.ErrNoRows) {
+		return errFileNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbconn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	qtx := instrumentDB(tx)
+
+	for _, tag := range tags {
+		tagID, err := qtx.UpsertTag(ctx, db.UpsertTagParams{OwnerID: ownerID, Name: tag})
+		if err != nil {
+			return fmt.Errorf("failed to save tag %s: %w", tag, err)
+		}
+		if err := qtx.AddFileTag(ctx, db.AddFileTagParams{FileID: file.ID, TagID: tagID}); err != nil {
+			return fmt.Errorf("failed to tag file: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// untagFile removes tags from a file in ownerID's library and returns how
+// many were removed
+func untagFile(ctx context.Context, ownerID, filename string, tags []string) (int64, error) {
+	file, err := queries.GetFileObject(ctx, db.GetFileObjectParams{OwnerID: ownerID, Name: filename})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errFileNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int64
+	for _, tag := range tags {
+		n, err := queries.RemoveFileTag(ctx, db.RemoveFileTagParams{FileID: file.ID, Tag: tag})
+		if err != nil {
+			return removed, fmt.Errorf("failed to remove tag %s: %w", tag, err)
+		}
+		removed += n
+	}
+	return removed, nil
+}
+
+// listFilesWithTags returns the files in ownerID's library carrying every one of tags
+func listFilesWithTags(ctx context.Context, ownerID string, tags []string) ([]fileListing, error) {
+	rows, err := queries.ListFilesWithTags(ctx, db.ListFilesWithTagsParams{
+		OwnerID: ownerID,
+		Tags:    tags,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]fileListing, 0, len(rows))
+	for _, row := range rows {
+		files = append(files, fileListing{Name: row.Name, Tags: row.Tags})
+	}
+	return files, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/smithy-go/middleware"
+)
+
+// เวลาสูงสุดของแต่ละงาน ปรับได้ด้วย env (เช่น 90s, 5m)
+var (
+	eventTimeout       = 5 * time.Minute  // EVENT_TIMEOUT: handling one event end to end
+	lineContentTimeout = 2 * time.Minute  // LINE_CONTENT_TIMEOUT: downloading a sent file from LINE
+	storageTimeout     = time.Minute      // STORAGE_TIMEOUT: one R2 operation or stored file fetch
+	queryTimeout       = 10 * time.Second // QUERY_TIMEOUT: one Postgres statement
+)
+
+// replyTimeout bounds a reply sent after the event's own deadline has passed,
+// so the user still hears about the timeout
+const replyTimeout = 10 * time.Second
+
+// eventsCtx is the parent of every event's context; cancelEvents aborts the
+// events still running when shutdown runs out of time
+var eventsCtx, cancelEvents = context.WithCancel(context.Background())
+
+// initTimeouts loads the deadlines from the environment
+func initTimeouts() {
+	eventTimeout = envDuration("EVENT_TIMEOUT", eventTimeout)
+	lineContentTimeout = envDuration("LINE_CONTENT_TIMEOUT", lineContentTimeout)
+	storageTimeout = envDuration("STORAGE_TIMEOUT", storageTimeout)
+	queryTimeout = envDuration("QUERY_TIMEOUT", queryTimeout)
+}
+
+// envDuration returns a positive duration environment variable, or def if unset
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		fatal("invalid "+name, fmt.Errorf("want a positive duration such as 30s, got %q", value))
+	}
+	return d
+}
+
+// withStatementTimeout adds queryTimeout to a Postgres connection string as
+// statement_timeout, so the server cancels any statement that runs longer.
+// It is enforced by Postgres rather than a context because the generated
+// queries read their rows after the driver call returns. A statement_timeout
+// already in the connection string wins. Only the server's connections get
+// it; the admin subcommands run without a limit.
+func withStatementTimeout(dsn string) string {
+	ms := strconv.FormatInt(queryTimeout.Milliseconds(), 10)
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		if q.Get("statement_timeout") == "" {
+			q.Set("statement_timeout", ms)
+			u.RawQuery = q.Encode()
+		}
+		return u.String()
+	}
+	if strings.Contains(dsn, "statement_timeout") {
+		return dsn
+	}
+	return strings.TrimSpace(dsn + " statement_timeout=" + ms)
+}
+
+// r2Timeout adds a middleware to the S3 client that bounds every operation by
+// storageTimeout. GetObject is left to its callers: its body is read after the
+// operation returns, and cancelling here would cut the download short.
+func r2Timeout(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("R2Timeout",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			if middleware.GetOperationName(ctx) == "GetObject" {
+				return next.HandleInitialize(ctx, in)
+			}
+			ctx, cancel := context.WithTimeout(ctx, storageTimeout)
+			defer cancel()
+			return next.HandleInitialize(ctx, in)
+		}), middleware.Before)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// ขนาด worker pool: EVENT_WORKERS และ EVENT_QUEUE_SIZE (ต่อ worker)
+const (
+	defaultEventWorkers   = 8
+	defaultEventQueueSize = 64
+
+	// enqueueTimeout is how long a webhook waits for room in a full queue
+	// before it is refused and left for LINE to redeliver
+	enqueueTimeout = 2 * time.Second
+)
+
+var (
+	errQueueFull    = errors.New("event queue is full")
+	errShuttingDown = errors.New("server is shutting down")
+)
+
+// eventWorkers processes the events received by callbackHandler
+var eventWorkers *eventPool
+
+// eventJob is a queued webhook event with the context it is handled in
+type eventJob struct {
+	ctx        context.Context
+	event      *linebot.Event
+	redelivery bool
+}
+
+// eventPool is a bounded pool of workers. Each worker has its own queue and
+// every event of a chat member goes to the same worker, so "upload x" is
+// always handled before the file sent after it.
+type eventPool struct {
+	mu     sync.RWMutex // guards closed against enqueues racing with close
+	closed bool
+	queues []chan eventJob
+	wg     sync.WaitGroup
+
+	running sync.Map // IDs of the events being handled
+}
+
+// initEventWorkers starts the event workers configured by EVENT_WORKERS and
+// EVENT_QUEUE_SIZE
+func initEventWorkers() {
+	workers := envInt("EVENT_WORKERS", defaultEventWorkers)
+	queueSize := envInt("EVENT_QUEUE_SIZE", defaultEventQueueSize)
+	pruneEvents(context.Background())
+	eventWorkers = newEventPool(workers, queueSize)
+	slog.Info("event workers started", "workers", workers, "queue_size", queueSize)
+	restoreUploadSessions(context.Background())
+	replayPendingEvents(context.Background())
+}
+
+// envInt returns a positive integer environment variable, or def if unset
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		fatal("invalid "+name, fmt.Errorf("want a positive integer, got %q", value))
+	}
+	return n
+}
+
+// newEventPool starts workers goroutines, each with a queue of queueSize events
+func newEventPool(workers, queueSize int) *eventPool {
+	p := &eventPool{queues: make([]chan eventJob, workers)}
+	for i := range p.queues {
+		p.queues[i] = make(chan eventJob, queueSize)
+		p.wg.Add(1)
+		go p.work(p.queues[i])
+	}
+	return p
+}
+
+// enqueue queues event for the worker of its sender. It waits up to
+// enqueueTimeout when that worker's queue is full.
+func (p *eventPool) enqueue(ctx context.Context, event *linebot.Event, redelivery bool) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errShuttingDown
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(sessionKey(event.Source)))
+	queue := p.queues[h.Sum32()%uint32(len(p.queues))]
+
+	job := eventJob{ctx: ctx, event: event, redelivery: redelivery}
+	select {
+	case queue <- job:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(enqueueTimeout)
+	defer timer.Stop()
+	select {
+	case queue <- job:
+		return nil
+	case <-timer.C:
+		return errQueueFull
+	}
+}
+
+// work handles the events of one queue in order until the queue is closed
+func (p *eventPool) work(queue <-chan eventJob) {
+	defer p.wg.Done()
+	for job := range queue {
+		p.handle(job)
+	}
+}
+
+// handle runs one event unless it is a duplicate; a panic is logged instead
+// of killing the worker. Once shutdown has cancelled the events, the rest of
+// the queue is saved for the next start instead.
+func (p *eventPool) handle(job eventJob) {
+	if eventsCtx.Err() != nil {
+		savePendingEvent(job)
+		return
+	}
+	id := eventID(job.ctx)
+	p.running.Store(id, struct{}{})
+	defer p.running.Delete(id)
+
+	defer func() {
+		if r := recover(); r != nil {
+			slog.ErrorContext(job.ctx, "panic while handling event", "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+		}
+	}()
+	ctx, cancel := context.WithTimeout(job.ctx, eventTimeout)
+	defer cancel()
+
+	if !claimEvent(ctx, job.redelivery) {
+		return
+	}
+	handleEvent(ctx, job.event)
+	completeEvent(ctx)
+}
+
+// queued returns the number of events waiting in all queues
+func (p *eventPool) queued() int {
+	n := 0
+	for _, queue := range p.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+// close stops accepting events and waits until the queued ones have been
+// handled or ctx is done
+func (p *eventPool) close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d events still queued: %w", p.queued(), ctx.Err())
+	}
+}
+
+// abandon is the last step of a shutdown that ran out of time: it saves the
+// events still queued and logs the ones that did not stop
+func (p *eventPool) abandon() {
+	for _, queue := range p.queues {
+		for job := range queue {
+			savePendingEvent(job)
+		}
+	}
+	p.running.Range(func(id, _ any) bool {
+		slog.Error("abandoning event that did not stop", "event_id", id)
+		return true
+	})
+}